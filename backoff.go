@@ -90,6 +90,12 @@ func (b *ExponentialBackoff) Next(retry int) (time.Duration, bool) {
 	return d, true
 }
 
+// MaxInterval returns the maximum wait interval. It also bounds the
+// server's Retry-After in RetryNotify.
+func (b *ExponentialBackoff) MaxInterval() time.Duration {
+	return time.Duration(int64(b.m)) * time.Millisecond
+}
+
 // -- Simple Backoff --
 
 // SimpleBackoff takes a list of fixed values for backoff intervals.
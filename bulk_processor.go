@@ -3,10 +3,51 @@ package dorisloader
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// BulkRetryFunc is called before a failed commit of the given execution
+// is retried. attempt starts at 1 and wait is the delay returned by the
+// backoff before the next attempt.
+type BulkRetryFunc func(executionId int64, attempt int, err error, wait time.Duration)
+
+// BulkEvent describes the outcome of a single commit of a worker.
+type BulkEvent struct {
+	Worker      int // -1 for rows dropped by Add
+	ExecutionId int64
+	TxnId       int64
+	Label       string
+	Err         error    // nil if the commit succeeded
+	Rows        [][]byte // the rows dropped, e.g. of a failed commit with DropFailedBatches
+}
+
+// BulkProcessorStats contains various statistics of a bulk processor.
+type BulkProcessorStats struct {
+	Flushed      int64 // number of times the flush interval has been invoked
+	Committed    int64 // # of times workers committed bulk requests
+	Succeeded    int64 // # of successful commits
+	Failed       int64 // # of failed commits
+	LoadedRows   int64 // # of rows loaded
+	FilteredRows int64 // # of rows filtered
+	DroppedRows  int64 // # of rows dropped, e.g. after failed commits
+	LoadBytes    int64 // # of bytes loaded
+
+	MinCommitTime time.Duration // shortest request of a commit
+	MaxCommitTime time.Duration // longest request of a commit
+	AvgCommitTime time.Duration // average request time of commits
+}
+
+// ErrBulkProcessorClosed is returned by Flush and TryAdd if the processor
+// is not running.
+var ErrBulkProcessorClosed = errors.New("bulk processor is closed")
+
+// bulkDrainPollInterval is the interval in which Drain checks the queue.
+const bulkDrainPollInterval = 10 * time.Millisecond
+
 type BulkProcessor struct {
 	c                    *Client
 	name                 string
@@ -14,19 +55,50 @@ type BulkProcessor struct {
 	table                string
 	bulkActions          int
 	bulkSize             int
+	maxBytesPerRequest   int
+	maxRowSize           int64
 	flushInterval        time.Duration
+	idleFlushInterval    time.Duration
+	flushOnlyOnInterval  bool
+	discardOnClose       bool
+	dropFailedBatches    bool
+	discarding           int32 // set atomically while Close discards rows
 	flusherStopC         chan struct{}
 	retryItemStatusCodes map[int]struct{}
 	numWorkers           int
 	executionId          int64
+	startNonce           string // unique per Start, for labels
 	rows                 chan []byte
+	queueSize            int // buffer size of rows
 	workerWg             sync.WaitGroup
 	workers              []*bulkWorker
 	backoff              Backoff
+	retryFunc            BulkRetryFunc
+	labelPrefixFunc      func() string
+	labelStore           LabelStore
+	maxConcurrent        int
+	requestSem           chan struct{} // limits the concurrent commit requests across workers
+	rateLimit            int64         // bytes per second across workers, 0 for no limit
+	rateLimiter          *rateLimiter
+	eventsEnabled        bool
+	eventsSize           int
+	eventsBlock          bool
+	events               chan BulkEvent
+	clock                Clock
+
+	statsMu         sync.Mutex
+	stats           BulkProcessorStats
+	commitTimeTotal time.Duration // sum of all commit request times
+	commitTimeCount int64         // # of commit requests timed
 
 	startedMu sync.Mutex
 	started   bool
 
+	// flushMu is held for reading by adds and flushes and for writing while
+	// the workers are stopped, so that neither runs once rows is closed
+	flushMu  sync.RWMutex
+	flushing bool // workers accept rows and flushes, guarded by flushMu
+
 	stopReconnC chan struct{}
 }
 
@@ -52,6 +124,174 @@ func NewBulkProcessor(
 		flushInterval:        flushInterval,
 		retryItemStatusCodes: retryItemStatusCodes,
 		backoff:              backoff,
+		clock:                realClock{},
+	}
+}
+
+// MaxBytesPerRequest sets a hard cap on the body size of a single stream
+// load request, including the newline after each row. A worker commits
+// its pending rows before adding a row that would exceed the cap, and Add
+// and TryAdd reject rows that are larger than the cap on their own. Zero
+// (the default) disables the cap.
+func (p *BulkProcessor) MaxBytesPerRequest(maxBytesPerRequest int) *BulkProcessor {
+	p.maxBytesPerRequest = maxBytesPerRequest
+	return p
+}
+
+// MaxRowSize makes Add and TryAdd reject rows larger than maxRowSize bytes, e.g. to
+// guard against a malformed huge row exhausting the memory of a BE.
+// Zero (the default) means no limit.
+func (p *BulkProcessor) MaxRowSize(maxRowSize int64) *BulkProcessor {
+	p.maxRowSize = maxRowSize
+	return p
+}
+
+// RetryFunc sets a function that is called each time a commit is retried.
+func (p *BulkProcessor) RetryFunc(retryFunc BulkRetryFunc) *BulkProcessor {
+	p.retryFunc = retryFunc
+	return p
+}
+
+// LabelPrefixFunc sets a function that is evaluated for every commit to
+// get the label prefix of the batch, e.g. the current hour as
+// "2024-06-01T13". The label is the prefix followed by a nonce unique to
+// the Start of the processor, the worker index and the execution id, e.g.
+// "2024-06-01T13_lx3k9a2b1c_0_42". As execution ids start over with every
+// Start, the nonce keeps the labels of a restarted processor from
+// colliding with those committed before.
+func (p *BulkProcessor) LabelPrefixFunc(labelPrefixFunc func() string) *BulkProcessor {
+	p.labelPrefixFunc = labelPrefixFunc
+	return p
+}
+
+// IdleFlushInterval makes every worker commit its outstanding rows once
+// no row has been added to it for the given duration. Unlike the flush
+// interval, it doesn't fire while rows keep coming in. Zero (the
+// default) disables it.
+func (p *BulkProcessor) IdleFlushInterval(idleFlushInterval time.Duration) *BulkProcessor {
+	p.idleFlushInterval = idleFlushInterval
+	return p
+}
+
+// SetClock replaces the source of time of the flush interval, the idle
+// flush interval and the commit time statistics, e.g. with a fake clock
+// in tests. It must be called before Start.
+func (p *BulkProcessor) SetClock(clock Clock) *BulkProcessor {
+	if clock == nil {
+		clock = realClock{}
+	}
+	p.clock = clock
+	return p
+}
+
+// QueueSize buffers up to n added rows that no worker has picked up yet,
+// so that Add doesn't block while all workers are committing. Zero (the
+// default) means Add waits for a worker. See QueueLen to monitor it.
+func (p *BulkProcessor) QueueSize(n int) *BulkProcessor {
+	p.queueSize = n
+	return p
+}
+
+// QueueLen returns the number of added rows waiting for a worker.
+// A queue that is constantly near QueueCap indicates backpressure.
+func (p *BulkProcessor) QueueLen() int {
+	return len(p.rows)
+}
+
+// QueueCap returns the number of added rows that can wait for a worker,
+// see QueueSize.
+func (p *BulkProcessor) QueueCap() int {
+	return cap(p.rows)
+}
+
+// FlushOnlyOnInterval makes workers commit only when the flush interval
+// (or the idle flush interval) fires, never because bulk actions or bulk
+// size are reached, to maximize the batch size. MaxBytesPerRequest still
+// applies. It requires a flush interval.
+func (p *BulkProcessor) FlushOnlyOnInterval(flushOnlyOnInterval bool) *BulkProcessor {
+	p.flushOnlyOnInterval = flushOnlyOnInterval
+	return p
+}
+
+// DiscardOnClose makes Close drop the rows that are not committed yet
+// instead of committing them, e.g. when they are stale after a crash.
+// By default, Close commits them.
+func (p *BulkProcessor) DiscardOnClose(discardOnClose bool) *BulkProcessor {
+	p.discardOnClose = discardOnClose
+	return p
+}
+
+// DropFailedBatches makes a worker drop the rows of a commit that still
+// fails after all retries, e.g. rejected for data quality, so that they
+// aren't sent again with the next batch. The dropped rows are counted as
+// DroppedRows and passed in BulkEvent.Rows; use Events to keep them.
+// By default, the worker keeps the rows and commits them with the next batch.
+func (p *BulkProcessor) DropFailedBatches(dropFailedBatches bool) *BulkProcessor {
+	p.dropFailedBatches = dropFailedBatches
+	return p
+}
+
+// MaxConcurrentRequests limits the number of commit requests in flight
+// across all workers to n, independently of the number of workers.
+// Zero (the default) means no limit.
+func (p *BulkProcessor) MaxConcurrentRequests(n int) *BulkProcessor {
+	p.maxConcurrent = n
+	return p
+}
+
+// RateLimit paces the commits of all workers so that no more than
+// bytesPerSec bytes, estimated from the rows, are sent per second on
+// average, e.g. to protect a small cluster. Workers wait before sending a
+// request until the budget allows it. Zero (the default) means no limit.
+func (p *BulkProcessor) RateLimit(bytesPerSec int64) *BulkProcessor {
+	p.rateLimit = bytesPerSec
+	return p
+}
+
+// LabelStore sets a store that the label of every batch is reserved in
+// before it is committed, and marked with the outcome in afterwards.
+// Batches without a label, see LabelPrefixFunc, are not recorded.
+func (p *BulkProcessor) LabelStore(labelStore LabelStore) *BulkProcessor {
+	p.labelStore = labelStore
+	return p
+}
+
+// EnableEvents makes the processor emit a BulkEvent per commit on the
+// channel returned by Events, buffered with the given size. If block is
+// false, events are dropped while the buffer is full; otherwise workers
+// wait for a reader.
+func (p *BulkProcessor) EnableEvents(size int, block bool) *BulkProcessor {
+	if size < 0 {
+		size = 0
+	}
+	p.eventsEnabled = true
+	p.eventsSize = size
+	p.eventsBlock = block
+	p.events = make(chan BulkEvent, size)
+	return p
+}
+
+// Events returns the channel of commit events, or nil if events are not
+// enabled. The channel is closed by Close.
+func (p *BulkProcessor) Events() <-chan BulkEvent {
+	return p.events
+}
+
+// lastStartNonce is the last value returned by newStartNonce.
+var lastStartNonce int64
+
+// newStartNonce returns a nonce based on the current time that is unique
+// within the process, and very likely across restarts of it.
+func newStartNonce() string {
+	for {
+		last := atomic.LoadInt64(&lastStartNonce)
+		next := time.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastStartNonce, last, next) {
+			return strconv.FormatInt(next, 36)
+		}
 	}
 }
 
@@ -72,8 +312,29 @@ func (p *BulkProcessor) Start(ctx context.Context) error {
 		p.numWorkers = 1
 	}
 
-	p.rows = make(chan []byte)
+	queueSize := p.queueSize
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p.rows = make(chan []byte, queueSize)
 	p.executionId = 0
+	p.startNonce = newStartNonce()
+	atomic.StoreInt32(&p.discarding, 0)
+	p.resetStats()
+	p.requestSem = nil
+	if p.maxConcurrent > 0 {
+		p.requestSem = make(chan struct{}, p.maxConcurrent)
+	}
+	if p.clock == nil {
+		p.clock = realClock{}
+	}
+	p.rateLimiter = nil
+	if p.rateLimit > 0 {
+		p.rateLimiter = newRateLimiter(p.rateLimit)
+	}
+	if p.eventsEnabled && p.events == nil {
+		p.events = make(chan BulkEvent, p.eventsSize)
+	}
 	p.stopReconnC = make(chan struct{})
 
 	// Create and start up workers.
@@ -90,6 +351,10 @@ func (p *BulkProcessor) Start(ctx context.Context) error {
 		go p.flusher(p.flushInterval)
 	}
 
+	p.flushMu.Lock()
+	p.flushing = true
+	p.flushMu.Unlock()
+
 	p.started = true
 
 	return nil
@@ -97,10 +362,14 @@ func (p *BulkProcessor) Start(ctx context.Context) error {
 
 func (p *BulkProcessor) checkInterval() error {
 
-	if p.bulkActions == 0 && p.bulkSize == 0 && p.flushInterval == 0 {
+	if p.bulkActions == 0 && p.bulkSize == 0 && p.flushInterval == 0 && p.idleFlushInterval == 0 {
 		return errors.New("bulk actions and bulk size and flush interval all is nil(0)")
 	}
 
+	if p.flushOnlyOnInterval && p.flushInterval <= 0 && p.idleFlushInterval <= 0 {
+		return errors.New("flush only on interval requires a flush interval")
+	}
+
 	return nil
 }
 
@@ -132,10 +401,24 @@ func (p *BulkProcessor) Close() error {
 		p.flusherStopC = nil
 	}
 
+	// Wait for flushes in progress and reject further ones
+	p.flushMu.Lock()
+	p.flushing = false
+	p.flushMu.Unlock()
+
 	// Stop all workers.
+	if p.discardOnClose {
+		atomic.StoreInt32(&p.discarding, 1)
+	}
 	close(p.rows)
 	p.workerWg.Wait()
 
+	// Stop emitting events
+	if p.events != nil {
+		close(p.events)
+		p.events = nil
+	}
+
 	p.started = false
 
 	return nil
@@ -144,32 +427,133 @@ func (p *BulkProcessor) Close() error {
 // Add adds a single request to commit by the BulkProcessorService.
 //
 // The caller is responsible for setting the index and type on the request.
+// A row that exceeds MaxRowSize or, alone, MaxBytesPerRequest is dropped
+// and reported as DroppedRows and in a BulkEvent; use TryAdd to get the
+// error instead. Rows added while the processor is not running are dropped
+// and counted as DroppedRows.
 func (p *BulkProcessor) Add(row []byte) {
+	p.flushMu.RLock()
+	defer p.flushMu.RUnlock()
+	if err := p.add(row); err != nil {
+		p.recordDropped(1)
+		if p.flushing {
+			p.emit(BulkEvent{Worker: -1, Err: err, Rows: [][]byte{row}})
+		}
+	}
+}
+
+// TryAdd is like Add, but returns an error if the row exceeds MaxRowSize
+// or, alone, MaxBytesPerRequest, instead of dropping it. It returns
+// ErrBulkProcessorClosed if the processor is not running.
+func (p *BulkProcessor) TryAdd(row []byte) error {
+	p.flushMu.RLock()
+	defer p.flushMu.RUnlock()
+	return p.add(row)
+}
+
+// add queues row. The caller must hold flushMu for reading, so that
+// Close can't close rows meanwhile.
+func (p *BulkProcessor) add(row []byte) error {
+	if !p.flushing {
+		return ErrBulkProcessorClosed
+	}
+	if p.maxRowSize > 0 && int64(len(row)) > p.maxRowSize {
+		return fmt.Errorf("row of %d bytes exceeds max row size of %d", len(row), p.maxRowSize)
+	}
+	// Each row is sent followed by a newline
+	if p.maxBytesPerRequest > 0 && len(row)+1 > p.maxBytesPerRequest {
+		return fmt.Errorf("row of %d bytes exceeds max bytes per request of %d", len(row), p.maxBytesPerRequest)
+	}
 	p.rows <- row
+	return nil
 }
 
 // Flush manually asks all workers to commit their outstanding requests.
 // It returns only when all workers acknowledge completion.
 func (p *BulkProcessor) Flush() error {
+	return p.FlushWithContext(context.Background())
+}
+
+// FlushWithContext is like Flush but gives up when ctx is done. The
+// returned error identifies the worker that did not acknowledge in time.
+// It returns ErrBulkProcessorClosed if the processor is not running.
+func (p *BulkProcessor) FlushWithContext(ctx context.Context) error {
+	p.flushMu.RLock()
+	defer p.flushMu.RUnlock()
+	if !p.flushing {
+		return ErrBulkProcessorClosed
+	}
 
 	for _, w := range p.workers {
-		w.flushC <- struct{}{}
-		<-w.flushAckC // wait for completion
+		if err := p.flushWorker(ctx, w); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// FlushWorker asks only worker i, counting from 0, to commit its
+// outstanding requests, e.g. to isolate a stuck worker. It returns when
+// the worker acknowledges completion.
+func (p *BulkProcessor) FlushWorker(i int) error {
+	p.flushMu.RLock()
+	defer p.flushMu.RUnlock()
+	if !p.flushing {
+		return ErrBulkProcessorClosed
+	}
+	if i < 0 || i >= len(p.workers) {
+		return fmt.Errorf("invalid worker %d: have %d workers", i, len(p.workers))
+	}
+	return p.flushWorker(context.Background(), p.workers[i])
+}
+
+// flushWorker asks w to commit its outstanding requests and waits for
+// its acknowledgement or until ctx is done.
+func (p *BulkProcessor) flushWorker(ctx context.Context, w *bulkWorker) error {
+	select {
+	case w.flushC <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("flush of worker %d: %w", w.i, ctx.Err())
+	}
+	select {
+	case <-w.flushAckC: // wait for completion
+	case <-ctx.Done():
+		// The worker still owes us an acknowledgement; take it
+		// when it arrives so the worker does not block forever.
+		go func(w *bulkWorker) { <-w.flushAckC }(w)
+		return fmt.Errorf("flush of worker %d: %w", w.i, ctx.Err())
+	}
+	return nil
+}
+
+// Drain waits until all queued rows have been picked up by the workers
+// and then flushes them, so that every row added before the call is
+// committed. Unlike Close, the processor remains usable afterwards.
+func (p *BulkProcessor) Drain(ctx context.Context) error {
+	for len(p.rows) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(bulkDrainPollInterval):
+		}
+	}
+	return p.FlushWithContext(ctx)
+}
+
 // flusher is a single goroutine that periodically asks all workers to
 // commit their outstanding bulk requests. It is only started if
 // FlushInterval is greater than 0.
 func (p *BulkProcessor) flusher(interval time.Duration) {
-	ticker := time.NewTicker(interval)
+	ticker := p.clock.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C: // Periodic flush
+		case <-ticker.C(): // Periodic flush
+			p.statsMu.Lock()
+			p.stats.Flushed++
+			p.statsMu.Unlock()
 			p.Flush() // TODO swallow errors here?
 
 		case <-p.flusherStopC:
@@ -179,6 +563,80 @@ func (p *BulkProcessor) flusher(interval time.Duration) {
 	}
 }
 
+// Stats returns the latest bulk processor statistics.
+func (p *BulkProcessor) Stats() BulkProcessorStats {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	stats := p.stats
+	if p.commitTimeCount > 0 {
+		stats.AvgCommitTime = p.commitTimeTotal / time.Duration(p.commitTimeCount)
+	}
+	return stats
+}
+
+// resetStats clears the statistics, e.g. on start.
+func (p *BulkProcessor) resetStats() {
+	p.statsMu.Lock()
+	p.stats = BulkProcessorStats{}
+	p.commitTimeTotal = 0
+	p.commitTimeCount = 0
+	p.statsMu.Unlock()
+}
+
+// recordCommitTime records the duration of a single commit request,
+// including failed attempts.
+func (p *BulkProcessor) recordCommitTime(d time.Duration) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	if p.commitTimeCount == 0 || d < p.stats.MinCommitTime {
+		p.stats.MinCommitTime = d
+	}
+	if d > p.stats.MaxCommitTime {
+		p.stats.MaxCommitTime = d
+	}
+	p.commitTimeTotal += d
+	p.commitTimeCount++
+}
+
+// updateStats records the outcome of a commit.
+func (p *BulkProcessor) updateStats(res *BulkResponse, err error) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.stats.Committed++
+	if err != nil {
+		p.stats.Failed++
+		return
+	}
+	p.stats.Succeeded++
+	if res != nil {
+		p.stats.LoadedRows += res.NumberLoadedRows
+		p.stats.FilteredRows += res.NumberFilteredRows
+		p.stats.LoadBytes += res.LoadBytes
+	}
+}
+
+// recordDropped records rows dropped after a failed commit.
+func (p *BulkProcessor) recordDropped(n int) {
+	p.statsMu.Lock()
+	p.stats.DroppedRows += int64(n)
+	p.statsMu.Unlock()
+}
+
+// emit sends e on the events channel according to the events policy.
+func (p *BulkProcessor) emit(e BulkEvent) {
+	if p.events == nil {
+		return
+	}
+	if p.eventsBlock {
+		p.events <- e
+		return
+	}
+	select {
+	case p.events <- e:
+	default:
+	}
+}
+
 func (p *BulkProcessor) DB() string {
 	return p.db
 }
@@ -0,0 +1,457 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBulkProcessorLabelsUniqueAcrossRestart(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil).
+		LabelPrefixFunc(func() string { return "prefix" })
+	for i := 0; i < 2; i++ {
+		if err := p.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		p.Add([]byte("a"))
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loads := ts.Loads()
+	if len(loads) != 2 {
+		t.Fatalf("got %d loads, want 2", len(loads))
+	}
+	first, second := loads[0].Header.Get(BULK_HEADER_LABEL_KEY), loads[1].Header.Get(BULK_HEADER_LABEL_KEY)
+	if first == second {
+		t.Errorf("label %q is reused after a restart", first)
+	}
+}
+
+func TestBulkProcessorFlushWithContextTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		<-release
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	defer close(release)
+
+	// The worker blocks in the commit of this row
+	p.Add([]byte("a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := p.FlushWithContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "worker 0") {
+		t.Errorf("err = %v, want a deadline error for worker 0", err)
+	}
+}
+
+func TestBulkProcessorEvents(t *testing.T) {
+	var mu sync.Mutex
+	var txnID int64
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		txnID++
+		return &BulkResponse{Status: BULK_STATUS_SUCCESS, TxnID: txnID, Label: load.Header.Get(BULK_HEADER_LABEL_KEY)}
+	})
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil).
+		LabelPrefixFunc(func() string { return "l" }).
+		EnableEvents(0, true)
+	events := p.Events()
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []BulkEvent)
+	go func() {
+		var got []BulkEvent
+		for e := range events {
+			got = append(got, e)
+		}
+		done <- got
+	}()
+	for i := 0; i < 3; i++ {
+		p.Add([]byte("a"))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-done
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3", len(got))
+	}
+	for i, e := range got {
+		if e.Err != nil || e.ExecutionId != int64(i+1) || e.TxnId != int64(i+1) || !strings.HasPrefix(e.Label, "l_") {
+			t.Errorf("event %d = %+v, want execution and txn id %d with a label", i, e, i+1)
+		}
+	}
+}
+
+func TestBulkProcessorLabelPrefixFuncHourBuckets(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 59, 0, 0, time.UTC))
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil).
+		SetClock(clock).
+		LabelPrefixFunc(func() string { return clock.Now().Format("2006-01-02T15") })
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		p.Add([]byte("a"))
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(2 * time.Minute)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loads := ts.Loads()
+	if len(loads) != 2 {
+		t.Fatalf("got %d loads, want 2", len(loads))
+	}
+	for i, prefix := range []string{"2024-06-01T12_", "2024-06-01T13_"} {
+		if label := loads[i].Header.Get(BULK_HEADER_LABEL_KEY); !strings.HasPrefix(label, prefix) || !strings.HasSuffix(label, "_0_"+strconv.Itoa(i+1)) {
+			t.Errorf("label %d = %q, want prefix %q, worker 0 and execution id %d", i, label, prefix, i+1)
+		}
+	}
+}
+
+func TestBulkProcessorFlushWithContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		<-release
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p.Add([]byte("a"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := p.FlushWithContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+
+	// The worker finishes eventually
+	close(release)
+	if err := p.Flush(); err != nil {
+		t.Errorf("flush after release: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBulkProcessorCommitTimeStats(t *testing.T) {
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		time.Sleep(5 * time.Millisecond)
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		p.Add([]byte("a"))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := p.Stats()
+	if stats.MinCommitTime < 5*time.Millisecond || stats.AvgCommitTime < stats.MinCommitTime || stats.MaxCommitTime < stats.AvgCommitTime {
+		t.Errorf("commit times min %v, avg %v, max %v, want at least 5ms in order", stats.MinCommitTime, stats.AvgCommitTime, stats.MaxCommitTime)
+	}
+}
+
+func TestBulkProcessorDrain(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 2, 100, 0, 0, NewConstantBackoff(0), nil)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	rows := 0
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 10; i++ {
+			p.Add([]byte(strconv.Itoa(rows)))
+			rows++
+		}
+		if err := p.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(p.rows); n != 0 {
+			t.Fatalf("%d rows still queued after Drain", n)
+		}
+
+		// Every row added so far has been committed
+		var sent int
+		for _, load := range ts.Loads() {
+			sent += strings.Count(load.Body, "\n")
+		}
+		if sent != rows {
+			t.Errorf("round %d: %d rows committed, want %d", round, sent, rows)
+		}
+	}
+}
+
+func TestBulkProcessorStartLoadCloseLoop(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 4, 3, 0, time.Millisecond, NewConstantBackoff(0), nil)
+	var rows int
+	for round := 0; round < 20; round++ {
+		if err := p.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush concurrently with the flusher and Close
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 5; i++ {
+				p.Flush()
+			}
+		}()
+		for i := 0; i < 10; i++ {
+			p.Add([]byte(strconv.Itoa(rows)))
+			rows++
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+	}
+
+	var sent int
+	for _, load := range ts.Loads() {
+		sent += strings.Count(load.Body, "\n")
+	}
+	if sent != rows {
+		t.Errorf("%d rows committed, want %d", sent, rows)
+	}
+}
+
+func TestBulkProcessorFlushOnlyOnInterval(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+	clock := newFakeClock(time.Now())
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 2, 1, time.Minute, NewConstantBackoff(0), nil).
+		SetClock(clock).
+		FlushOnlyOnInterval(true)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 10; i++ {
+		p.Add([]byte(strconv.Itoa(i)))
+	}
+	for p.QueueLen() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := len(ts.Loads()); n != 0 {
+		t.Fatalf("got %d loads before the interval, want none", n)
+	}
+
+	// The flusher may not have created its ticker yet
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ts.Loads()) == 0 && time.Now().Before(deadline) {
+		clock.Advance(time.Minute)
+		time.Sleep(5 * time.Millisecond)
+	}
+	loads := ts.Loads()
+	if len(loads) != 1 || strings.Count(loads[0].Body, "\n") != 10 {
+		t.Errorf("loads = %+v, want all 10 rows in one load", loads)
+	}
+}
+
+func TestBulkProcessorQueueLen(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil).
+		QueueSize(8)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p.QueueLen() != 0 || p.QueueCap() != 8 {
+		t.Errorf("QueueLen = %d, QueueCap = %d, want 0 and 8", p.QueueLen(), p.QueueCap())
+	}
+
+	// The worker blocks in the commit of the first row
+	p.Add([]byte("a"))
+	<-entered
+	for i := 0; i < 5; i++ {
+		p.Add([]byte("b"))
+	}
+	if got := p.QueueLen(); got != 5 {
+		t.Errorf("QueueLen = %d, want 5", got)
+	}
+
+	close(release)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBulkProcessorFlushIntervalFakeClock(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+	clock := newFakeClock(time.Now())
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 100, 0, time.Minute, NewConstantBackoff(0), nil).
+		SetClock(clock)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// The flusher creates its ticker when it starts
+	waitFor("the ticker", func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.timers) > 0
+	})
+
+	const flushes = 3
+	for i := 1; i <= flushes; i++ {
+		p.Add([]byte(strconv.Itoa(i)))
+		for p.QueueLen() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(30 * time.Second)
+		clock.Advance(30 * time.Second)
+		waitFor("a flush", func() bool { return p.Stats().Flushed == int64(i) && len(ts.Loads()) == i })
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if got := p.Stats().Flushed; got != flushes {
+		t.Errorf("Flushed = %d, want %d", got, flushes)
+	}
+	if got := len(ts.Loads()); got != flushes {
+		t.Errorf("got %d loads, want %d", got, flushes)
+	}
+}
+
+func TestBulkProcessorFlushWorker(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 3, 100, 0, 0, NewConstantBackoff(0), nil).
+		LabelPrefixFunc(func() string { return "prefix" })
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	const rows = 30
+	for i := 0; i < rows; i++ {
+		p.Add([]byte(strconv.Itoa(i)))
+	}
+	for p.QueueLen() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Labels are prefix_<nonce>_<worker>_<execution id>
+	var committed int
+	for _, i := range []int{1, 0, 2} {
+		before := len(ts.Loads())
+		if err := p.FlushWorker(i); err != nil {
+			t.Fatal(err)
+		}
+		for _, load := range ts.Loads()[before:] {
+			parts := strings.Split(load.Header.Get(BULK_HEADER_LABEL_KEY), "_")
+			if len(parts) != 4 || parts[2] != strconv.Itoa(i) {
+				t.Errorf("flushing worker %d committed %q", i, load.Header.Get(BULK_HEADER_LABEL_KEY))
+			}
+			committed += strings.Count(load.Body, "\n")
+		}
+	}
+	if committed != rows {
+		t.Errorf("%d rows committed, want %d", committed, rows)
+	}
+
+	for _, i := range []int{-1, 3} {
+		if err := p.FlushWorker(i); err == nil {
+			t.Errorf("FlushWorker(%d) succeeded, want error", i)
+		}
+	}
+}
+
+func TestBulkProcessorTryAddAfterClose(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.TryAdd([]byte("a")); !errors.Is(err, ErrBulkProcessorClosed) {
+		t.Errorf("TryAdd after Close = %v, want ErrBulkProcessorClosed", err)
+	}
+	p.Add([]byte("a"))
+	if n := p.Stats().DroppedRows; n != 1 {
+		t.Errorf("DroppedRows = %d, want 1 after Add on a closed processor", n)
+	}
+}
@@ -2,15 +2,130 @@ package dorisloader
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	BULK_HEADER_LABEL_KEY = "label"
+	BULK_HEADER_LABEL_KEY                  = "label"
+	BULK_HEADER_COLUMN_SEPARATOR_KEY       = "column_separator"
+	BULK_HEADER_LINE_DELIMITER_KEY         = "line_delimiter"
+	BULK_HEADER_STRIP_OUTER_ARRAY_KEY      = "strip_outer_array"
+	BULK_HEADER_SQL_KEY                    = "sql"
+	BULK_HEADER_FORMAT_KEY                 = "format"
+	BULK_HEADER_TIMEOUT_KEY                = "timeout"
+	BULK_HEADER_COLUMNS_KEY                = "columns"
+	BULK_HEADER_GROUP_COMMIT_KEY           = "group_commit"
+	BULK_HEADER_ENCLOSE_KEY                = "enclose"
+	BULK_HEADER_ESCAPE_KEY                 = "escape"
+	BULK_HEADER_SKIP_LINES_KEY             = "skip_lines"
+	BULK_HEADER_TRIM_DOUBLE_QUOTES_KEY     = "trim_double_quotes"
+	BULK_HEADER_JSONPATHS_KEY              = "jsonpaths"
+	BULK_HEADER_JSON_ROOT_KEY              = "json_root"
+	BULK_HEADER_NUM_AS_STRING_KEY          = "num_as_string"
+	BULK_HEADER_FUZZY_PARSE_KEY            = "fuzzy_parse"
+	BULK_HEADER_READ_JSON_BY_LINE_KEY      = "read_json_by_line"
+	BULK_HEADER_WHERE_KEY                  = "where"
+	BULK_HEADER_PARTITIONS_KEY             = "partitions"
+	BULK_HEADER_MAX_FILTER_RATIO_KEY       = "max_filter_ratio"
+	BULK_HEADER_EXEC_MEM_LIMIT_KEY         = "exec_mem_limit"
+	BULK_HEADER_STRICT_MODE_KEY            = "strict_mode"
+	BULK_HEADER_MERGE_TYPE_KEY             = "merge_type"
+	BULK_HEADER_DELETE_KEY                 = "delete"
+	BULK_HEADER_PARTIAL_COLUMNS_KEY        = "partial_columns"
+	BULK_HEADER_SEQUENCE_COL_KEY           = "function_column.sequence_col"
+	BULK_HEADER_TIMEZONE_KEY               = "timezone"
+	BULK_HEADER_SEND_BATCH_PARALLELISM_KEY = "send_batch_parallelism"
+	BULK_HEADER_LOAD_TO_SINGLE_TABLET_KEY  = "load_to_single_tablet"
+	BULK_HEADER_MEMTABLE_ON_SINK_NODE_KEY  = "memtable_on_sink_node"
+	BULK_HEADER_COMPRESS_TYPE_KEY          = "compress_type"
+	BULK_HEADER_TXN_ID_KEY                 = "txn_id"
+	BULK_HEADER_COMMENT_KEY                = "comment"
 )
 
+const (
+	BULK_GROUP_COMMIT_ASYNC = "async_mode"
+	BULK_GROUP_COMMIT_SYNC  = "sync_mode"
+	BULK_GROUP_COMMIT_OFF   = "off_mode"
+)
+
+const (
+	BULK_FORMAT_CSV            = "csv"
+	BULK_FORMAT_CSV_WITH_NAMES = "csv_with_names"
+	BULK_FORMAT_JSON           = "json"
+	BULK_FORMAT_PARQUET        = "parquet"
+	BULK_FORMAT_ORC            = "orc"
+)
+
+const (
+	BULK_STATUS_SUCCESS         = "Success"
+	BULK_STATUS_PUBLISH_TIMEOUT = "Publish Timeout"
+	BULK_STATUS_LABEL_EXISTS    = "Label Already Exists"
+	BULK_STATUS_FAIL            = "Fail"
+
+	// ExistingJobStatus of a finished load
+	BULK_JOB_STATUS_FINISHED = "FINISHED"
+
+	// maximum length of a label accepted by Doris
+	bulkMaxLabelLength = 128
+
+	// interval between polls of the load state in PublishTimeoutPollVisible
+	bulkVisiblePollInterval = 500 * time.Millisecond
+
+	// default time to wait for the data to be visible in
+	// PublishTimeoutPollVisible
+	bulkDefaultWaitForVisible = 30 * time.Second
+
+	// default time the client waits beyond the load timeout
+	bulkDefaultTimeoutMargin = 5 * time.Second
+
+	// default maximum capacity of the rows kept for reuse by Reset
+	bulkMaxRetainedRows = 4096
+
+	// default header of the idempotency key
+	bulkDefaultIdempotencyHeader = "Idempotency-Key"
+)
+
+// defaultSuccessStatuses are the load statuses that count as success by
+// default.
+var defaultSuccessStatuses = []string{BULK_STATUS_SUCCESS, BULK_STATUS_PUBLISH_TIMEOUT}
+
+// PublishTimeoutPolicy specifies how a "Publish Timeout" status is handled.
+type PublishTimeoutPolicy int
+
+const (
+	// PublishTimeoutError returns a *BulkStatusError.
+	PublishTimeoutError PublishTimeoutPolicy = iota
+	// PublishTimeoutSucceed treats the load as successful.
+	PublishTimeoutSucceed
+	// PublishTimeoutPollVisible polls the load state until the data is
+	// visible, returning an error if it isn't within the WaitForVisible
+	// timeout, 30 seconds by default.
+	PublishTimeoutPollVisible
+)
+
+// BulkStatusError is returned if a stream load responds with a status
+// other than "Success".
+type BulkStatusError struct {
+	Response *BulkResponse
+}
+
+func (e *BulkStatusError) Error() string {
+	return fmt.Sprintf("stream load %s: %s: %s", e.Response.Label, e.Response.Status, e.Response.Message)
+}
+
 type BulkService struct {
 	c     *Client
 	rows  [][]byte
@@ -32,8 +147,63 @@ type BulkService struct {
 	execMemLimit int64
 	// Stream load 导入可以开启 strict mode 模式
 	strictMode bool
+	// 列分隔符
+	columnSeparator string
+	// 行分隔符
+	lineDelimiter string
+	// 发送前校验每行是否为合法 JSON
+	validateJSON bool
+	// 以 JSON 数组形式发送，由 Doris 剥离最外层数组
+	stripOuterArray bool
+	// 基于 SQL 的导入（http_stream），设置后忽略 db/table
+	sql string
+	// Publish Timeout 的处理策略
+	publishTimeoutPolicy PublishTimeoutPolicy
+	// PublishTimeoutPollVisible 时等待数据可见的最长时间
+	waitForVisible time.Duration
+	// Add 时不复制行数据（零拷贝），调用方不得再修改已添加的行
+	noCopyRows bool
+	// 导入数据格式，默认为 csv
+	format string
+	// 请求的 Content-Type，为空时根据 format 推断
+	contentType string
+	// 导入超时时间，单位秒
+	timeout int
+	// 客户端在导入超时时间之外额外等待的时间
+	timeoutMargin time.Duration
+	// 是否压缩请求体（客户端开启 gzip 时同样生效）
+	compress bool
+	// 请求体超过该字节数时才压缩
+	compressMinBytes int
 
-	headers http.Header // custom request-level HTTP headers
+	// 标签过长时是否哈希截断
+	truncateLabel bool
+	// 基于 Reader 导入时的进度回调
+	onProgress func(bytesRead int64)
+	// 解析响应的 Decoder，为空时使用 Client 的
+	decoder Decoder
+	// 导入有被过滤的行时，拉取错误日志并对应到原始行
+	trackRowErrors bool
+	// 发送标签的请求头名称，为空时使用 BULK_HEADER_LABEL_KEY
+	labelHeaderKey string
+	// 视为成功的导入状态，为空时使用 Client 的
+	successStatuses []string
+	// 请求的 HTTP 方法，为空时使用 PUT
+	method string
+	// 幂等键的请求头名称，为空时使用 Idempotency-Key
+	idempotencyHeader string
+	// 根据请求体的哈希生成幂等键
+	idempotencyFromBody bool
+	// 发送数据前检查集群健康状态
+	preflight bool
+	// 单行的最大字节数，0 表示不限制
+	maxRowSize int64
+	// Reset 时保留行数组的最大容量，0 表示 bulkMaxRetainedRows
+	maxRetainedRows int
+
+	headers   http.Header // custom request-level HTTP headers
+	optionErr error       // first invalid Option, returned when the request is built
+	rowErr    error       // first row rejected by Add, fails the batch until Reset
 
 	// estimated bulk size in bytes, up to the request index sizeInBytesCursor
 	sizeInBytes       int64
@@ -42,25 +212,73 @@ type BulkService struct {
 
 func NewBulkService(c *Client) *BulkService {
 
-	b := &BulkService{c: c}
+	b := &BulkService{c: c, timeoutMargin: bulkDefaultTimeoutMargin}
 	b.Header("Expect", "100-continue")
 
 	return b
 }
 
 type BulkResponse struct {
-	TxnID                int    `json:"TxnId"`
+	TxnID                int64  `json:"TxnId"`
 	Label                string `json:"Label"`
 	Status               string `json:"Status"`
 	ExistingJobStatus    string `json:"ExistingJobStatus"`
 	Message              string `json:"Message"`
-	NumberTotalRows      int    `json:"NumberTotalRows"`
-	NumberLoadedRows     int    `json:"NumberLoadedRows"`
-	NumberFilteredRows   int    `json:"NumberFilteredRows"`
-	NumberUnselectedRows int    `json:"NumberUnselectedRows"`
-	LoadBytes            int    `json:"LoadBytes"`
-	LoadTimeMs           int    `json:"LoadTimeMs"`
+	NumberTotalRows      int64  `json:"NumberTotalRows"`
+	NumberLoadedRows     int64  `json:"NumberLoadedRows"`
+	NumberFilteredRows   int64  `json:"NumberFilteredRows"`
+	NumberUnselectedRows int64  `json:"NumberUnselectedRows"`
+	LoadBytes            int64  `json:"LoadBytes"`
+	LoadTimeMs           int64  `json:"LoadTimeMs"`
 	ErrorURL             string `json:"ErrorURL"`
+	GroupCommit          bool   `json:"GroupCommit"` // true if the load was handled by group commit
+	Comment              string `json:"Comment"`
+
+	// RejectedRecords holds the rejected rows, if the server returns them
+	// inline, as RejectedRecords or ErrorRows depending on the version.
+	RejectedRecords []string `json:"RejectedRecords"`
+	ErrorRows       []string `json:"ErrorRows"`
+
+	// BackendHost is the host that served the load, see Response.
+	BackendHost string `json:"-"`
+	// SentBytes is the number of body bytes sent, see Response.
+	SentBytes int64 `json:"-"`
+	// RowErrors lists the rejected rows if TrackRowErrors is enabled.
+	RowErrors []RowError `json:"-"`
+}
+
+// IsSuccess reports whether the load succeeded.
+func (r *BulkResponse) IsSuccess() bool {
+	return r.Status == BULK_STATUS_SUCCESS
+}
+
+// IsPublishTimeout reports whether the load was written, but is not
+// visible yet.
+func (r *BulkResponse) IsPublishTimeout() bool {
+	return r.Status == BULK_STATUS_PUBLISH_TIMEOUT
+}
+
+// IsLabelExists reports whether the load was rejected because its label
+// was used before. ExistingJobStatus holds the status of that load.
+func (r *BulkResponse) IsLabelExists() bool {
+	return r.Status == BULK_STATUS_LABEL_EXISTS
+}
+
+// IsLoadedBefore reports whether the load was rejected because its label
+// was used before by a load that completed, e.g. when retrying after a
+// lost response. The rows are thus loaded.
+func (r *BulkResponse) IsLoadedBefore() bool {
+	return r.IsLabelExists() &&
+		(r.ExistingJobStatus == BULK_JOB_STATUS_FINISHED || r.ExistingJobStatus == LOAD_STATE_VISIBLE)
+}
+
+// FilterRatio returns the ratio of filtered rows to total rows, or 0 if
+// the load had no rows.
+func (r *BulkResponse) FilterRatio() float64 {
+	if r.NumberTotalRows == 0 {
+		return 0
+	}
+	return float64(r.NumberFilteredRows) / float64(r.NumberTotalRows)
 }
 
 func (s *BulkService) DB(db string) *BulkService {
@@ -73,42 +291,620 @@ func (s *BulkService) Table(table string) *BulkService {
 	return s
 }
 
+// MaxFilterRatio sets the maximum ratio of rows that may be filtered,
+// within [0, 1]. It is sent with full precision, so small ratios such as
+// 0.0001 are kept.
 func (s *BulkService) MaxFilterRatio(maxFilterRatio float64) *BulkService {
 	s.maxFilterRatio = maxFilterRatio
+	s.setHeader(BULK_HEADER_MAX_FILTER_RATIO_KEY, strconv.FormatFloat(maxFilterRatio, 'f', -1, 64))
 	return s
 }
 
 func (s *BulkService) Label(label string) *BulkService {
 	s.label = label
-	s.Header(BULK_HEADER_LABEL_KEY, label)
+	s.setHeader(s.labelKey(), label)
+	return s
+}
+
+// LabelHeaderKey sets the name of the header the label is sent in, e.g.
+// for a proxy in front of Doris that expects a different passthrough
+// header. It defaults to BULK_HEADER_LABEL_KEY. A label set earlier is
+// moved to the new header.
+func (s *BulkService) LabelHeaderKey(key string) *BulkService {
+	if err := validateOption(key, ""); err != nil {
+		s.setOptionErr(err)
+		return s
+	}
+	label := s.headers.Get(s.labelKey())
+	if label != "" {
+		s.headers.Del(s.labelKey())
+	}
+	s.labelHeaderKey = key
+	if label != "" {
+		s.setHeader(key, label)
+	}
+	return s
+}
+
+// labelKey returns the name of the header the label is sent in.
+func (s *BulkService) labelKey() string {
+	if s.labelHeaderKey != "" {
+		return s.labelHeaderKey
+	}
+	return BULK_HEADER_LABEL_KEY
+}
+
+// Comment annotates the load with text, which Doris stores with the load
+// job, e.g. to trace the pipeline run that produced it in SHOW LOAD.
+// An empty text removes the comment.
+func (s *BulkService) Comment(text string) *BulkService {
+	if text == "" {
+		s.headers.Del(BULK_HEADER_COMMENT_KEY)
+		return s
+	}
+	if err := validateOption(BULK_HEADER_COMMENT_KEY, text); err != nil {
+		s.setOptionErr(err)
+		return s
+	}
+	s.setHeader(BULK_HEADER_COMMENT_KEY, text)
+	return s
+}
+
+// Format sets the format of the data, e.g. BULK_FORMAT_CSV (the Doris
+// default) or BULK_FORMAT_JSON.
+func (s *BulkService) Format(format string) *BulkService {
+	s.format = format
+	s.setHeader(BULK_HEADER_FORMAT_KEY, format)
+	return s
+}
+
+// ContentType overrides the Content-Type of the request, which is
+// otherwise derived from the format.
+func (s *BulkService) ContentType(contentType string) *BulkService {
+	s.contentType = contentType
+	return s
+}
+
+// Timeout sets the timeout of the load in seconds. If the context passed
+// to Do has no deadline, the request is given a deadline of the timeout
+// plus a margin (see TimeoutMargin), so the client doesn't give up on a
+// load the server would still complete.
+func (s *BulkService) Timeout(seconds int) *BulkService {
+	s.timeout = seconds
+	s.setHeader(BULK_HEADER_TIMEOUT_KEY, strconv.Itoa(seconds))
+	return s
+}
+
+// TimeoutMargin sets how much longer than the load timeout the client
+// waits for a response. It defaults to 5 seconds.
+func (s *BulkService) TimeoutMargin(margin time.Duration) *BulkService {
+	s.timeoutMargin = margin
+	return s
+}
+
+// requestTimeout returns the client-side timeout derived from the load
+// timeout, or 0 if no load timeout is set.
+func (s *BulkService) requestTimeout() time.Duration {
+	if s.timeout <= 0 {
+		return 0
+	}
+	return time.Duration(s.timeout)*time.Second + s.timeoutMargin
+}
+
+// GroupCommit sets the group commit mode, e.g. BULK_GROUP_COMMIT_ASYNC,
+// which lets Doris batch many small loads server-side. Loads handled by
+// group commit report GroupCommit in the response and may have no TxnID.
+// Group commit cannot be combined with a label. An unknown mode makes the
+// request fail when it is built.
+func (s *BulkService) GroupCommit(mode string) *BulkService {
+	switch mode {
+	case BULK_GROUP_COMMIT_ASYNC, BULK_GROUP_COMMIT_SYNC, BULK_GROUP_COMMIT_OFF:
+	default:
+		s.setOptionErr(fmt.Errorf("invalid group commit mode %q", mode))
+		return s
+	}
+	s.setHeader(BULK_HEADER_GROUP_COMMIT_KEY, mode)
+	return s
+}
+
+// Compress enables gzip compression of the request body for this
+// service, in addition to compression enabled on the client (SetGzip).
+func (s *BulkService) Compress(compress bool) *BulkService {
+	s.compress = compress
+	return s
+}
+
+// CompressMinBytes sets the size a body must exceed to be compressed when
+// compression is enabled. Smaller bodies are sent uncompressed.
+func (s *BulkService) CompressMinBytes(n int) *BulkService {
+	s.compressMinBytes = n
+	return s
+}
+
+// SuccessStatuses sets the load statuses that count as success, e.g. for
+// Doris forks reporting "OK", overriding the ones of the client (see
+// SetSuccessStatuses). A "Publish Timeout" in the set is still subject to
+// the PublishTimeoutPolicy.
+func (s *BulkService) SuccessStatuses(statuses []string) *BulkService {
+	s.successStatuses = statuses
+	return s
+}
+
+// isSuccessStatus reports whether status counts as success.
+func (s *BulkService) isSuccessStatus(status string) bool {
+	statuses := s.successStatuses
+	if statuses == nil {
+		statuses = s.c.SuccessStatuses()
+	}
+	for _, st := range statuses {
+		if st == status {
+			return true
+		}
+	}
+	return false
+}
+
+// IdempotencyKey sends key in the idempotency key header (see
+// IdempotencyKeyHeader), so that a gateway in front of Doris can drop
+// retries of the same batch. An empty key removes it.
+func (s *BulkService) IdempotencyKey(key string) *BulkService {
+	if key == "" {
+		s.headers.Del(s.idempotencyKeyHeader())
+		return s
+	}
+	s.setHeader(s.idempotencyKeyHeader(), key)
+	return s
+}
+
+// IdempotencyKeyFromBody makes every request carry an idempotency key
+// derived from its body (see BodyIdempotencyKey) unless one is set with
+// IdempotencyKey. Retries of the same rows thus send the same key.
+func (s *BulkService) IdempotencyKeyFromBody(enabled bool) *BulkService {
+	s.idempotencyFromBody = enabled
+	return s
+}
+
+// IdempotencyKeyHeader sets the header the idempotency key is sent in.
+// It defaults to "Idempotency-Key".
+func (s *BulkService) IdempotencyKeyHeader(name string) *BulkService {
+	if err := validateOption(name, ""); err != nil {
+		s.setOptionErr(err)
+		return s
+	}
+	key := s.headers.Get(s.idempotencyKeyHeader())
+	if key != "" {
+		s.headers.Del(s.idempotencyKeyHeader())
+	}
+	s.idempotencyHeader = name
+	if key != "" {
+		s.setHeader(name, key)
+	}
+	return s
+}
+
+// idempotencyKeyHeader returns the header the idempotency key is sent in.
+func (s *BulkService) idempotencyKeyHeader() string {
+	if s.idempotencyHeader != "" {
+		return s.idempotencyHeader
+	}
+	return bulkDefaultIdempotencyHeader
+}
+
+// BodyIdempotencyKey returns an idempotency key for a request body, the
+// hex-encoded SHA-256 of it.
+func BodyIdempotencyKey(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Preflight makes every load check the health of the cluster with the FE
+// before sending the body, so that a large body isn't uploaded to a
+// cluster that is down. The outcome is cached for a few seconds, see
+// Client.CachedHealth. It is off by default.
+func (s *BulkService) Preflight(preflight bool) *BulkService {
+	s.preflight = preflight
+	return s
+}
+
+// MaxRowSize sets the maximum size of a single row in bytes, e.g. to
+// guard against a malformed huge row exhausting the memory of a BE.
+// Larger rows are not added; instead the whole batch fails with an error
+// when the request is built, until Reset. Zero (the default) means no
+// limit.
+func (s *BulkService) MaxRowSize(maxRowSize int64) *BulkService {
+	s.maxRowSize = maxRowSize
+	return s
+}
+
+// checkRowSize returns an error if row exceeds the MaxRowSize.
+func (s *BulkService) checkRowSize(row []byte) error {
+	if s.maxRowSize > 0 && int64(len(row)) > s.maxRowSize {
+		return fmt.Errorf("row of %d bytes exceeds max row size of %d", len(row), s.maxRowSize)
+	}
+	return nil
+}
+
+// Method sets the HTTP method of the load requests, e.g. "POST" for
+// endpoints other than stream load. It defaults to "PUT".
+func (s *BulkService) Method(method string) *BulkService {
+	s.method = method
+	return s
+}
+
+// httpMethod returns the HTTP method of the load requests.
+func (s *BulkService) httpMethod() string {
+	if s.method != "" {
+		return s.method
+	}
+	return http.MethodPut
+}
+
+// Decoder sets the decoder for the responses of this service, overriding
+// the one of the client.
+func (s *BulkService) Decoder(decoder Decoder) *BulkService {
+	s.decoder = decoder
+	return s
+}
+
+// TrackRowErrors makes the service fetch the error log of loads that
+// rejected rows and report them in BulkResponse.RowErrors, mapped back to
+// the added rows where possible. It costs an extra request per such load
+// and is off by default.
+func (s *BulkService) TrackRowErrors(trackRowErrors bool) *BulkService {
+	s.trackRowErrors = trackRowErrors
+	return s
+}
+
+// OnProgress sets a callback that is invoked with the total number of
+// bytes read so far while loading from a reader (see LoadFrom), about
+// every MiB and once the reader is exhausted.
+func (s *BulkService) OnProgress(onProgress func(bytesRead int64)) *BulkService {
+	s.onProgress = onProgress
+	return s
+}
+
+// TruncateLabel makes labels longer than the 128 characters allowed by
+// Doris be truncated, with a hash of the full label appended so that the
+// result is deterministic and unique. Otherwise such labels are rejected
+// before the request is sent.
+func (s *BulkService) TruncateLabel(truncateLabel bool) *BulkService {
+	s.truncateLabel = truncateLabel
+	return s
+}
+
+// SubLabel returns the label of the index-th part of a batch labeled
+// base, e.g. "mylabel_1". It is deterministic, so a retried part gets the
+// same label.
+func SubLabel(base string, index int) string {
+	return base + "_" + strconv.Itoa(index)
+}
+
+// checkLabel validates label against the length and character set
+// accepted by Doris, truncating it if TruncateLabel is set.
+func (s *BulkService) checkLabel(label string) (string, error) {
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == ':') {
+			return "", fmt.Errorf("invalid label %q: only letters, digits, '-', '_' and ':' are allowed", label)
+		}
+	}
+	if len(label) <= bulkMaxLabelLength {
+		return label, nil
+	}
+	if !s.truncateLabel {
+		return "", fmt.Errorf("invalid label %q: longer than %d characters", label, bulkMaxLabelLength)
+	}
+	sum := sha1.Sum([]byte(label))
+	hash := hex.EncodeToString(sum[:])
+	return label[:bulkMaxLabelLength-len(hash)-1] + "_" + hash, nil
+}
+
+// CSVOptions groups the options of CSV loads.
+type CSVOptions struct {
+	Separator        string // column separator, see ColumnSeparator
+	LineDelimiter    string // line delimiter, see LineDelimiter
+	Enclose          string // single character enclosing fields, e.g. `"`
+	Escape           string // single character escaping the enclose character within fields
+	SkipLines        int    // number of leading lines to skip
+	TrimDoubleQuotes bool   // trim the outermost double quotes of fields
+	WithNames        bool   // the first line holds the column names (format csv_with_names)
+}
+
+// validate checks for invalid combinations of options.
+func (o CSVOptions) validate() error {
+	if len(o.Enclose) > 1 {
+		return fmt.Errorf("CSV enclose %q must be a single character", o.Enclose)
+	}
+	if len(o.Escape) > 1 {
+		return fmt.Errorf("CSV escape %q must be a single character", o.Escape)
+	}
+	if o.Escape != "" && o.Enclose == "" {
+		return errors.New("CSV escape requires enclose")
+	}
+	if o.SkipLines < 0 {
+		return errors.New("CSV skip lines must not be negative")
+	}
+	if o.WithNames && o.SkipLines > 0 {
+		return errors.New("CSV with names cannot be combined with skip lines")
+	}
+	return nil
+}
+
+// CSV applies all CSV options and sets the format to BULK_FORMAT_CSV, or
+// BULK_FORMAT_CSV_WITH_NAMES if WithNames is set. Invalid combinations
+// make the request fail when it is built.
+func (s *BulkService) CSV(opts CSVOptions) *BulkService {
+	if err := opts.validate(); err != nil {
+		s.setOptionErr(err)
+		return s
+	}
+
+	if opts.WithNames {
+		s.Format(BULK_FORMAT_CSV_WITH_NAMES)
+	} else {
+		s.Format(BULK_FORMAT_CSV)
+	}
+	if opts.Separator != "" {
+		s.ColumnSeparator(opts.Separator)
+	}
+	if opts.LineDelimiter != "" {
+		s.LineDelimiter(opts.LineDelimiter)
+	}
+	if opts.Enclose != "" {
+		s.setHeader(BULK_HEADER_ENCLOSE_KEY, opts.Enclose)
+	}
+	if opts.Escape != "" {
+		s.setHeader(BULK_HEADER_ESCAPE_KEY, opts.Escape)
+	}
+	if opts.SkipLines > 0 {
+		s.setHeader(BULK_HEADER_SKIP_LINES_KEY, strconv.Itoa(opts.SkipLines))
+	}
+	if opts.TrimDoubleQuotes {
+		s.setHeader(BULK_HEADER_TRIM_DOUBLE_QUOTES_KEY, "true")
+	}
+	return s
+}
+
+// JSONOptions groups the options of JSON loads.
+type JSONOptions struct {
+	JsonPaths       string // JSON paths of the columns, e.g. `["$.id","$.name"]`
+	JsonRoot        string // JSON path of the root to load, e.g. `$.data`
+	StripOuterArray bool   // the rows are sent as a single JSON array, see StripOuterArray
+	NumAsString     bool   // parse numbers as strings to avoid precision loss
+	FuzzyParse      bool   // all rows have the same field order, which speeds up parsing
+	ReadJsonByLine  bool   // every line holds one JSON row
+}
+
+// validate checks for invalid combinations of options.
+func (o JSONOptions) validate() error {
+	if o.StripOuterArray && o.ReadJsonByLine {
+		return errors.New("JSON strip outer array cannot be combined with read json by line")
+	}
+	if o.FuzzyParse && !o.StripOuterArray {
+		return errors.New("JSON fuzzy parse requires strip outer array")
+	}
+	return nil
+}
+
+// JSON applies all JSON options and sets the format to BULK_FORMAT_JSON.
+// Invalid combinations make the request fail when it is built.
+func (s *BulkService) JSON(opts JSONOptions) *BulkService {
+	if err := opts.validate(); err != nil {
+		s.setOptionErr(err)
+		return s
+	}
+
+	s.Format(BULK_FORMAT_JSON)
+	if opts.JsonPaths != "" {
+		s.setHeader(BULK_HEADER_JSONPATHS_KEY, opts.JsonPaths)
+	}
+	if opts.JsonRoot != "" {
+		s.setHeader(BULK_HEADER_JSON_ROOT_KEY, opts.JsonRoot)
+	}
+	if opts.StripOuterArray {
+		s.StripOuterArray(true)
+	}
+	if opts.NumAsString {
+		s.setHeader(BULK_HEADER_NUM_AS_STRING_KEY, "true")
+	}
+	if opts.FuzzyParse {
+		s.setHeader(BULK_HEADER_FUZZY_PARSE_KEY, "true")
+	}
+	if opts.ReadJsonByLine {
+		s.setHeader(BULK_HEADER_READ_JSON_BY_LINE_KEY, "true")
+	}
+	return s
+}
+
+// setOptionErr records the first invalid option, which is returned when
+// the request is built.
+func (s *BulkService) setOptionErr(err error) {
+	if s.optionErr == nil {
+		s.optionErr = err
+	}
+}
+
+// SQL switches the service to an SQL-driven load, e.g.
+// INSERT INTO db.table SELECT * FROM http_stream("format" = "json").
+// The target is taken from the query, so DB and Table are ignored.
+// It cannot be combined with Columns or Where.
+func (s *BulkService) SQL(query string) *BulkService {
+	s.sql = query
+	s.setHeader(BULK_HEADER_SQL_KEY, query)
 	return s
 }
 
 func (s *BulkService) Where(where string) *BulkService {
 	s.where = where
+	s.setHeader(BULK_HEADER_WHERE_KEY, where)
 	return s
 }
 
 func (s *BulkService) Partition(partition string) *BulkService {
 	s.partition = partition
+	s.setHeader(BULK_HEADER_PARTITIONS_KEY, partition)
 	return s
 }
 
 func (s *BulkService) Columns(columns string) *BulkService {
 	s.columns = columns
+	s.setHeader(BULK_HEADER_COLUMNS_KEY, columns)
 	return s
 }
 
+// ColumnsFromStruct sets the columns to the json names of the fields of
+// the struct v (or a pointer to it), in declaration order. Fields of
+// embedded structs are included as encoding/json would, and fields
+// tagged with "-" are skipped.
+func (s *BulkService) ColumnsFromStruct(v interface{}) *BulkService {
+	return s.Columns(strings.Join(structColumns(reflect.TypeOf(v)), ","))
+}
+
+// structColumns returns the json names of the fields of t.
+func structColumns(t reflect.Type) []string {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				columns = append(columns, structColumns(ft)...)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
 func (s *BulkService) ExecMemLimit(execMemLimit int64) *BulkService {
 	s.execMemLimit = execMemLimit
+	s.setHeader(BULK_HEADER_EXEC_MEM_LIMIT_KEY, strconv.FormatInt(execMemLimit, 10))
 	return s
 }
 
 func (s *BulkService) StrictMode(strictMode bool) *BulkService {
 	s.strictMode = strictMode
+	s.setHeader(BULK_HEADER_STRICT_MODE_KEY, strconv.FormatBool(strictMode))
+	return s
+}
+
+// ColumnSeparator sets the column separator. Separators containing
+// non-printable bytes are sent in the \xNN form understood by Doris.
+func (s *BulkService) ColumnSeparator(columnSeparator string) *BulkService {
+	s.columnSeparator = encodeSeparator(columnSeparator)
+	s.setHeader(BULK_HEADER_COLUMN_SEPARATOR_KEY, s.columnSeparator)
+	return s
+}
+
+// HexColumnSeparator sets the column separator to the given bytes,
+// always sent in the \xNN form understood by Doris.
+func (s *BulkService) HexColumnSeparator(columnSeparator []byte) *BulkService {
+	s.columnSeparator = hexSeparator(columnSeparator)
+	s.setHeader(BULK_HEADER_COLUMN_SEPARATOR_KEY, s.columnSeparator)
+	return s
+}
+
+// LineDelimiter sets the line delimiter. Delimiters containing
+// non-printable bytes are sent in the \xNN form understood by Doris.
+func (s *BulkService) LineDelimiter(lineDelimiter string) *BulkService {
+	s.lineDelimiter = encodeSeparator(lineDelimiter)
+	s.setHeader(BULK_HEADER_LINE_DELIMITER_KEY, s.lineDelimiter)
+	return s
+}
+
+// StripOuterArray makes the service send the rows as a single JSON array,
+// e.g. [row1,row2], which Doris strips before loading the elements.
+func (s *BulkService) StripOuterArray(stripOuterArray bool) *BulkService {
+	s.stripOuterArray = stripOuterArray
+	s.setHeader(BULK_HEADER_STRIP_OUTER_ARRAY_KEY, strconv.FormatBool(stripOuterArray))
 	return s
 }
 
+// PublishTimeoutPolicy sets how a load returning "Publish Timeout" is
+// handled. Such a load has been written but is not visible yet.
+// The default is PublishTimeoutError.
+func (s *BulkService) PublishTimeoutPolicy(policy PublishTimeoutPolicy) *BulkService {
+	s.publishTimeoutPolicy = policy
+	return s
+}
+
+// WaitForVisible sets the PublishTimeoutPollVisible policy, polling the
+// load state for at most timeout until the data is visible. A timeout of
+// 0 waits for 30 seconds.
+func (s *BulkService) WaitForVisible(timeout time.Duration) *BulkService {
+	s.publishTimeoutPolicy = PublishTimeoutPollVisible
+	s.waitForVisible = timeout
+	return s
+}
+
+// CopyRows controls whether Add copies the rows it is given (the default).
+// Disabling it avoids the copy, but the caller must then not modify a row
+// after adding it, or the body and the estimated size will diverge.
+func (s *BulkService) CopyRows(copyRows bool) *BulkService {
+	s.noCopyRows = !copyRows
+	return s
+}
+
+// ValidateJSON enables checking that every row is valid JSON before the
+// request is sent. It is off by default.
+func (s *BulkService) ValidateJSON(validateJSON bool) *BulkService {
+	s.validateJSON = validateJSON
+	return s
+}
+
+// Option sets an arbitrary stream load option header, e.g.
+// Option("memtable_on_sink_node", "true"), for options without a
+// dedicated method. Only the header syntax is validated, not whether
+// Doris understands the option; an invalid name or a value containing
+// CR, LF or NUL makes the request fail when it is built.
+func (s *BulkService) Option(name, value string) *BulkService {
+	if err := validateOption(name, value); err != nil {
+		s.setOptionErr(err)
+		return s
+	}
+	s.setHeader(name, value)
+	return s
+}
+
+// validateOption guards against header injection through Option.
+func validateOption(name, value string) error {
+	if name == "" {
+		return errors.New("option name is empty")
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c <= ' ' || c >= 0x7f || c == ':' {
+			return fmt.Errorf("invalid option name %q", name)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("invalid value for option %q", name)
+	}
+	return nil
+}
+
 func (s *BulkService) Header(name string, value string) *BulkService {
 	if s.headers == nil {
 		s.headers = http.Header{}
@@ -117,11 +913,55 @@ func (s *BulkService) Header(name string, value string) *BulkService {
 	return s
 }
 
+// setHeader sets a load option header, replacing any previous value,
+// so that calling an option setter twice doesn't send duplicate headers.
+func (s *BulkService) setHeader(name string, value string) {
+	if s.headers == nil {
+		s.headers = http.Header{}
+	}
+	s.headers.Set(name, value)
+}
+
+// Headers merges headers into the request-level headers. Values of keys
+// present in headers replace the existing ones; other headers, e.g.
+// Expect or the label set earlier, are kept. Use ReplaceHeaders to
+// discard the existing headers.
 func (s *BulkService) Headers(headers http.Header) *BulkService {
+	if s.headers == nil {
+		s.headers = http.Header{}
+	}
+	for key, values := range headers {
+		s.headers.Del(key)
+		for _, v := range values {
+			s.headers.Add(key, v)
+		}
+	}
+	return s
+}
+
+// ReplaceHeaders replaces all request-level headers, including Expect and
+// the headers set by option setters such as Label.
+func (s *BulkService) ReplaceHeaders(headers http.Header) *BulkService {
 	s.headers = headers
 	return s
 }
 
+// encodeSeparator returns sep unchanged if it only contains printable
+// ASCII, and its hex form otherwise.
+func encodeSeparator(sep string) string {
+	for i := 0; i < len(sep); i++ {
+		if sep[i] < 0x20 || sep[i] >= 0x7f {
+			return hexSeparator([]byte(sep))
+		}
+	}
+	return sep
+}
+
+// hexSeparator returns sep in the \xNN form, e.g. "\x01".
+func hexSeparator(sep []byte) string {
+	return `\x` + hex.EncodeToString(sep)
+}
+
 func (s *BulkService) EstimatedSizeInBytes() int64 {
 	if s.sizeInBytesCursor == len(s.rows) {
 		return s.sizeInBytes
@@ -137,49 +977,214 @@ func (s *BulkService) estimateSizeInBytes(r []byte) int64 {
 	return int64(len(r))
 }
 
+// renderedSizeInBytes returns the size of the body of n rows of the given
+// total size, including the delimiters added by bodyAsString.
+func (s *BulkService) renderedSizeInBytes(size int64, n int) int64 {
+	switch {
+	case n == 0 || s.isBinaryFormat():
+		return size
+	case s.stripOuterArray:
+		// [row1,row2]
+		return size + int64(n) + 1
+	default:
+		// row1\nrow2\n
+		return size + int64(n)
+	}
+}
+
 func (s *BulkService) NumberOfRows() int {
 	return len(s.rows)
 }
 
+// Rows returns a copy of the rows added so far, e.g. to inspect them in
+// tests or log them before a commit. Modifying it doesn't affect the
+// service.
+func (s *BulkService) Rows() [][]byte {
+	rows := make([][]byte, len(s.rows))
+	for i, row := range s.rows {
+		rows[i] = append([]byte(nil), row...)
+	}
+	return rows
+}
+
+// PeekBody returns the body that Do would send for the rows added so far,
+// before compression. The rows are kept.
+func (s *BulkService) PeekBody() (string, error) {
+	return s.bodyAsString()
+}
+
 func (s *BulkService) bodyAsString() (string, error) {
 	// Pre-allocate to reduce allocs
 	var buf strings.Builder
-	buf.Grow(int(s.EstimatedSizeInBytes()))
+	buf.Grow(int(s.EstimatedSizeInBytes()) + len(s.rows) + 2)
+
+	if s.stripOuterArray {
+		buf.WriteByte('[')
+	}
 
-	for _, row := range s.rows {
+	binary := s.isBinaryFormat()
+
+	for i, row := range s.rows {
+
+		if s.validateJSON && !json.Valid(row) {
+			return "", fmt.Errorf("row %d is not valid JSON", i)
+		}
+
+		if s.stripOuterArray {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(row)
+			continue
+		}
 
 		buf.Write(row)
-		buf.WriteByte('\n')
+		if !binary {
+			buf.WriteByte('\n')
+		}
 
 	}
 
+	if s.stripOuterArray {
+		buf.WriteByte(']')
+	}
+
 	return buf.String(), nil
 }
 
 func (s *BulkService) buildUrlPath() string {
+	if s.sql != "" {
+		return "/api/_http_stream"
+	}
+	return streamLoadPath(s.db, s.table)
+}
+
+// streamLoadPath returns the path of the stream load API for db and table.
+func streamLoadPath(db, table string) string {
 	path := "/api/"
-	path = path + s.db + "/"
-	path = path + s.table + "/_stream_load"
+	path = path + db + "/"
+	path = path + table + "/_stream_load"
 	return path
 }
 
+// Reset clears the rows. Their backing array is kept for reuse, unless it
+// grew beyond maxRetainedRows (bulkMaxRetainedRows by default), e.g. after
+// a burst, in which case it is released to the garbage collector.
 func (s *BulkService) Reset() {
-	s.rows = make([][]byte, 0)
+	maxRetained := s.maxRetainedRows
+	if maxRetained == 0 {
+		maxRetained = bulkMaxRetainedRows
+	}
+	if cap(s.rows) > maxRetained {
+		s.rows = nil
+	} else {
+		for i := range s.rows {
+			s.rows[i] = nil
+		}
+		s.rows = s.rows[:0]
+	}
 	s.sizeInBytes = 0
 	s.sizeInBytesCursor = 0
+	s.rowErr = nil
+}
+
+// ResetAll resets the service to the state returned by NewBulkService,
+// clearing rows, db, table, headers and all load options, e.g. to reuse
+// it from a sync.Pool. Like Reset, it keeps the backing array of the rows
+// for reuse. Use Reset to clear the rows only.
+func (s *BulkService) ResetAll() {
+	s.Reset()
+	*s = BulkService{c: s.c, timeoutMargin: bulkDefaultTimeoutMargin, rows: s.rows}
+	s.Header("Expect", "100-continue")
 }
 
+// Add adds rows to the batch. Rows exceeding the MaxRowSize are not
+// added and make the batch fail.
 func (s *BulkService) Add(rows ...[]byte) *BulkService {
-	s.rows = append(s.rows, rows...)
+	for _, row := range rows {
+		if err := s.checkRowSize(row); err != nil {
+			if s.rowErr == nil {
+				s.rowErr = err
+			}
+			continue
+		}
+		if !s.noCopyRows {
+			row = append([]byte(nil), row...)
+		}
+		s.rows = append(s.rows, row)
+	}
 	return s
 }
 
-func (s *BulkService) Do(ctx context.Context) (*BulkResponse, error) {
+// Validate checks the options of the service for invalid values and
+// combinations without sending anything. Do and the other load methods
+// call it as well.
+func (s *BulkService) Validate() error {
+	if s.optionErr != nil {
+		return s.optionErr
+	}
+	if !(s.maxFilterRatio >= 0 && s.maxFilterRatio <= 1) {
+		return fmt.Errorf("max filter ratio %v must be within [0, 1]", s.maxFilterRatio)
+	}
+	if s.sql != "" && (s.columns != "" || s.where != "") {
+		return errors.New("SQL cannot be combined with Columns or Where")
+	}
+	if s.sql == "" && (s.db == "" || s.table == "") {
+		return errors.New("DB and Table are required")
+	}
+
+	isJSON := strings.EqualFold(s.format, BULK_FORMAT_JSON)
+	if s.stripOuterArray && !isJSON {
+		return errors.New("StripOuterArray requires the json format")
+	}
+	for _, key := range []string{BULK_HEADER_JSONPATHS_KEY, BULK_HEADER_JSON_ROOT_KEY, BULK_HEADER_READ_JSON_BY_LINE_KEY} {
+		if s.headers.Get(key) != "" && !isJSON {
+			return fmt.Errorf("%s requires the json format", key)
+		}
+	}
+	if s.stripOuterArray && strings.EqualFold(s.headers.Get(BULK_HEADER_READ_JSON_BY_LINE_KEY), "true") {
+		return errors.New("StripOuterArray cannot be combined with read_json_by_line")
+	}
+
+	mergeType := s.headers.Get(BULK_HEADER_MERGE_TYPE_KEY)
+	if strings.EqualFold(mergeType, "MERGE") && s.headers.Get(BULK_HEADER_DELETE_KEY) == "" {
+		return errors.New("merge_type MERGE requires the delete condition")
+	}
+	if s.headers.Get(BULK_HEADER_DELETE_KEY) != "" && !strings.EqualFold(mergeType, "MERGE") {
+		return errors.New("the delete condition requires merge_type MERGE")
+	}
+	if strings.EqualFold(s.headers.Get(BULK_HEADER_PARTIAL_COLUMNS_KEY), "true") && s.columns == "" {
+		return errors.New("partial_columns requires Columns")
+	}
+
+	if label := s.headers.Get(s.labelKey()); label != "" {
+		if _, err := s.checkLabel(label); err != nil {
+			return err
+		}
+		if mode := s.headers.Get(BULK_HEADER_GROUP_COMMIT_KEY); mode != "" && mode != BULK_GROUP_COMMIT_OFF {
+			return errors.New("group commit cannot be combined with a label")
+		}
+	}
+
+	return nil
+}
+
+// BuildRequest composes the request options for the stream load
+// (path, headers and body) without sending them. Do calls it internally.
+func (s *BulkService) BuildRequest(ctx context.Context) (*PerformRequestOptions, error) {
+
+	if s.rowErr != nil {
+		return nil, s.rowErr
+	}
 
 	if s.NumberOfRows() == 0 {
 		return nil, errors.New("No bulk rows to commit")
 	}
 
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Get body
 	body, err := s.bodyAsString()
 	if err != nil {
@@ -189,25 +1194,370 @@ func (s *BulkService) Do(ctx context.Context) (*BulkResponse, error) {
 	// Build url
 	path := s.buildUrlPath()
 
+	opt := &PerformRequestOptions{
+		Method:      s.httpMethod(),
+		Path:        path,
+		Body:        body,
+		ContentType: s.buildContentType(),
+		Headers:     s.headers,
+		Decoder:     s.decoder,
+	}
+	if s.idempotencyFromBody && s.headers.Get(s.idempotencyKeyHeader()) == "" {
+		opt.Headers = s.headers.Clone()
+		if opt.Headers == nil {
+			opt.Headers = http.Header{}
+		}
+		opt.Headers.Set(s.idempotencyKeyHeader(), BodyIdempotencyKey(body))
+	}
+	if s.isBinaryFormat() {
+		// Already compressed
+		gzip := false
+		opt.Gzip = &gzip
+	} else if s.compress || s.c.GzipEnabled() {
+		gzip := len(body) > s.compressMinBytes
+		opt.Gzip = &gzip
+	}
+
+	return opt, nil
+}
+
+// buildContentType returns the Content-Type of the request body.
+func (s *BulkService) buildContentType() string {
+	if s.contentType != "" {
+		return s.contentType
+	}
+	if strings.EqualFold(s.format, BULK_FORMAT_JSON) {
+		return "application/json"
+	}
+	if s.format == "" || strings.EqualFold(s.format, BULK_FORMAT_CSV) || strings.EqualFold(s.format, BULK_FORMAT_CSV_WITH_NAMES) {
+		return "text/plain"
+	}
+	if s.isBinaryFormat() {
+		return "application/octet-stream"
+	}
+	return ""
+}
+
+// isBinaryFormat reports whether the format is a binary one, e.g. parquet,
+// whose body is sent as is, without line delimiters or compression.
+func (s *BulkService) isBinaryFormat() bool {
+	return strings.EqualFold(s.format, BULK_FORMAT_PARQUET) || strings.EqualFold(s.format, BULK_FORMAT_ORC)
+}
+
+func (s *BulkService) Do(ctx context.Context) (*BulkResponse, error) {
+
+	opt, err := s.BuildRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := s.perform(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reset so the request can be reused
+	s.Reset()
+
+	return ret, nil
+}
+
+// DoWithLabel is like Do, but uses label for this call only. The label
+// and headers configured on the service are left unchanged.
+func (s *BulkService) DoWithLabel(ctx context.Context, label string) (*BulkResponse, error) {
+
+	opt, err := s.BuildRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opt.Headers = opt.Headers.Clone()
+	if opt.Headers == nil {
+		opt.Headers = http.Header{}
+	}
+	opt.Headers.Set(s.labelKey(), label)
+
+	ret, err := s.perform(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reset so the request can be reused
+	s.Reset()
+
+	return ret, nil
+}
+
+// LoadFrom streams the content of r to the table, bypassing the rows
+// added to the service.
+//
+// If r is an io.Seeker, it is rewound to its current position to follow
+// the redirect of the FE to a BE. Otherwise the redirect can't be
+// followed, so either buffer r or send the request to a BE directly.
+// If compression is enabled, r is compressed while it is sent rather
+// than read into memory first.
+func (s *BulkService) LoadFrom(ctx context.Context, r io.Reader) (*BulkResponse, error) {
+	return s.perform(ctx, s.buildReaderRequest(r, s.headers))
+}
+
+// LoadFromWithChecksum is like LoadFrom, but computes the MD5 of the
+// content and sends it in the Content-MD5 header so the server can verify
+// its integrity. r is rewound to its current position before sending.
+func (s *BulkService) LoadFromWithChecksum(ctx context.Context, r io.ReadSeeker) (*BulkResponse, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	headers := s.headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+
+	return s.perform(ctx, s.buildReaderRequest(r, headers))
+}
+
+// buildReaderRequest composes the request options for loading the
+// content of r. Binary formats such as parquet are never compressed.
+func (s *BulkService) buildReaderRequest(r io.Reader, headers http.Header) *PerformRequestOptions {
+	if s.onProgress != nil {
+		if rs, ok := r.(io.ReadSeeker); ok {
+			r = &progressReadSeeker{progressReader: progressReader{r: rs, fn: s.onProgress}, s: rs}
+		} else {
+			r = &progressReader{r: r, fn: s.onProgress}
+		}
+	}
+	opt := &PerformRequestOptions{
+		Method:      s.httpMethod(),
+		Path:        s.buildUrlPath(),
+		Body:        r,
+		ContentType: s.buildContentType(),
+		Headers:     headers,
+		Decoder:     s.decoder,
+	}
+	if s.isBinaryFormat() {
+		gzip := false
+		opt.Gzip = &gzip
+	} else if s.compress {
+		gzip := true
+		opt.Gzip = &gzip
+	}
+	return opt
+}
+
+// progressReaderInterval is the number of bytes after which a
+// progressReader reports progress.
+const progressReaderInterval = 1 << 20
+
+// progressReader reports the number of bytes read to fn in intervals.
+type progressReader struct {
+	r        io.Reader
+	fn       func(bytesRead int64)
+	n        int64 // bytes read
+	reported int64 // bytes read when last reported
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.n-r.reported >= progressReaderInterval || (err == io.EOF && r.n != r.reported) {
+		r.reported = r.n
+		r.fn(r.n)
+	}
+	return n, err
+}
+
+// progressReadSeeker is a progressReader that can be rewound, e.g. to
+// follow a redirect. Progress is reported from the new position on.
+type progressReadSeeker struct {
+	progressReader
+	s io.Seeker
+}
+
+func (r *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.s.Seek(offset, whence)
+	if err == nil && (offset != 0 || whence != io.SeekCurrent) {
+		r.n = 0
+		r.reported = 0
+	}
+	return pos, err
+}
+
+// perform sends the request and decodes the stream load response.
+func (s *BulkService) perform(ctx context.Context, opt *PerformRequestOptions) (*BulkResponse, error) {
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	if label := opt.Headers.Get(s.labelKey()); label != "" {
+		if mode := opt.Headers.Get(BULK_HEADER_GROUP_COMMIT_KEY); mode != "" && mode != BULK_GROUP_COMMIT_OFF {
+			return nil, errors.New("group commit cannot be combined with a label")
+		}
+		checked, err := s.checkLabel(label)
+		if err != nil {
+			return nil, err
+		}
+		if checked != label {
+			opt.Headers = opt.Headers.Clone()
+			opt.Headers.Set(s.labelKey(), checked)
+		}
+	}
+
+	if s.preflight {
+		if err := s.c.CachedHealth(ctx); err != nil {
+			return nil, fmt.Errorf("preflight: %w", err)
+		}
+	}
+
 	// Get response
-	res, err := s.c.PerformRequest(ctx, PerformRequestOptions{
-		Method:  "PUT",
-		Path:    path,
-		Body:    body,
-		Headers: s.headers,
-	})
+	reqCtx := ctx
+	if _, ok := ctx.Deadline(); !ok && s.requestTimeout() > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, s.requestTimeout())
+		defer cancel()
+	}
+	start := time.Now()
+	res, err := s.c.PerformRequest(reqCtx, *opt)
+	if s.c.latencyRecorder != nil {
+		s.c.latencyRecorder.Record(s.db, s.table, time.Since(start))
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Return results
 	ret := new(BulkResponse)
-	if err := s.c.decoder.Decode(res.Body, ret); err != nil {
+	if err := s.c.decoderFor(opt).Decode(res.Body, ret); err != nil {
 		return nil, err
 	}
+	ret.BackendHost = res.BackendHost
+	ret.SentBytes = res.SentBytes
+
+	if len(ret.RejectedRecords) == 0 && len(ret.ErrorRows) > 0 {
+		ret.RejectedRecords = ret.ErrorRows
+	}
+
+	if s.trackRowErrors && len(ret.RejectedRecords) > 0 {
+		// Rejected rows returned inline; no need to fetch the error log
+		ret.RowErrors = inlineRowErrors(ret.RejectedRecords, s.rows)
+	} else if s.trackRowErrors && ret.ErrorURL != "" {
+		log, err := s.c.FetchErrorLog(ctx, ret.ErrorURL)
+		if err != nil {
+			s.c.debugf("load %s: fetching error log: %v", ret.Label, err)
+		} else {
+			ret.RowErrors = rowErrors(log, s.rows)
+		}
+	}
+
+	switch {
+	case ret.IsLoadedBefore():
+		s.c.debugf("load %s: label exists, loaded before", ret.Label)
+	case !s.isSuccessStatus(ret.Status):
+		return nil, &BulkStatusError{Response: ret}
+	case ret.IsPublishTimeout():
+		s.c.debugf("load %s: publish timeout, data is written but not yet visible", ret.Label)
+		switch s.publishTimeoutPolicy {
+		case PublishTimeoutSucceed:
+		case PublishTimeoutPollVisible:
+			if err := s.waitVisible(ctx, ret.Label); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, &BulkStatusError{Response: ret}
+		}
+	}
+
+	return ret, nil
+}
+
+// waitVisible polls the state of the load with the given label until it
+// is VISIBLE or waitForVisible has elapsed.
+func (s *BulkService) waitVisible(ctx context.Context, label string) error {
+	timeout := s.waitForVisible
+	if timeout <= 0 {
+		timeout = bulkDefaultWaitForVisible
+	}
+	return s.c.WaitForVisible(ctx, s.db, label, timeout)
+}
+
+// DoChunked commits the rows in several stream loads, each with a body of
+// at most maxBytesPerChunk bytes, delimiters included (before
+// compression). Rows are never split; a row larger than
+// maxBytesPerChunk is sent on its own. If a label is set, each chunk gets
+// the label suffixed with its index, e.g. "mylabel_0", "mylabel_1" (see
+// SubLabel). As the split only depends on the rows and maxBytesPerChunk,
+// retrying with the same rows and label sends the same sub-labels, so
+// chunks committed before are reported as loaded before by Doris (see
+// BulkResponse.IsLoadedBefore) instead of being loaded twice.
+//
+// On failure, it returns the responses of the chunks committed so far.
+// If a label is set, it keeps all rows in the service, so that a retry
+// derives the same chunks; otherwise it keeps the rows not committed yet.
+func (s *BulkService) DoChunked(ctx context.Context, maxBytesPerChunk int64) ([]*BulkResponse, error) {
+
+	if s.rowErr != nil {
+		return nil, s.rowErr
+	}
+
+	if s.NumberOfRows() == 0 {
+		return nil, errors.New("No bulk rows to commit")
+	}
+
+	var chunks [][][]byte
+	var start int
+	var size int64
+	for i, row := range s.rows {
+		n := s.estimateSizeInBytes(row)
+		if i > start && s.renderedSizeInBytes(size+n, i-start+1) > maxBytesPerChunk {
+			chunks = append(chunks, s.rows[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	chunks = append(chunks, s.rows[start:])
+
+	base := s.headers.Get(s.labelKey())
+
+	var results []*BulkResponse
+	var committed int
+	for i, rows := range chunks {
+		chunk := getBulkService(s.c)
+		buf := chunk.rows
+		*chunk = *s
+		// Copy, so that Reset of the chunk doesn't clear our rows
+		chunk.rows = append(buf, rows...)
+		chunk.sizeInBytes = 0
+		chunk.sizeInBytesCursor = 0
+		if base != "" {
+			chunk.label = SubLabel(base, i)
+			chunk.headers = s.headers.Clone()
+			chunk.headers.Set(s.labelKey(), chunk.label)
+		}
+		res, err := chunk.Do(ctx)
+		putBulkService(chunk)
+		if err != nil {
+			if base == "" {
+				s.rows = s.rows[committed:]
+				s.sizeInBytes = 0
+				s.sizeInBytesCursor = 0
+			}
+			return results, err
+		}
+		results = append(results, res)
+		committed += len(rows)
+	}
 
 	// Reset so the request can be reused
 	s.Reset()
 
-	return ret, nil
+	return results, nil
 }
@@ -0,0 +1,24 @@
+package dorisloader
+
+import "sync"
+
+// bulkServicePool holds services for reuse. A single pool serves all
+// tables, as a service taken from it is configured from scratch anyway.
+var bulkServicePool sync.Pool
+
+// getBulkService returns a pristine service, taken from the pool if
+// possible. A pooled service keeps the backing array of its rows.
+func getBulkService(c *Client) *BulkService {
+	if s, ok := bulkServicePool.Get().(*BulkService); ok {
+		s.c = c
+		return s
+	}
+	return NewBulkService(c)
+}
+
+// putBulkService resets s and returns it to the pool.
+func putBulkService(s *BulkService) {
+	s.ResetAll()
+	s.c = nil
+	bulkServicePool.Put(s)
+}
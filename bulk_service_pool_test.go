@@ -0,0 +1,41 @@
+package dorisloader
+
+import "testing"
+
+func TestBulkServiceResetAll(t *testing.T) {
+	s := NewBulkService(nil).DB("db").Table("tbl").Label("l")
+	s.Add([]byte("a"), []byte("b"))
+	s.ResetAll()
+
+	if s.db != "" || s.table != "" || s.label != "" || s.headers.Get(BULK_HEADER_LABEL_KEY) != "" {
+		t.Errorf("ResetAll kept the configuration: db=%q table=%q label=%q", s.db, s.table, s.label)
+	}
+	if s.headers.Get("Expect") != "100-continue" {
+		t.Errorf("Expect header = %q, want 100-continue", s.headers.Get("Expect"))
+	}
+	if s.NumberOfRows() != 0 || cap(s.rows) < 2 {
+		t.Errorf("rows = %d with capacity %d, want none with capacity >= 2", s.NumberOfRows(), cap(s.rows))
+	}
+}
+
+func BenchmarkBulkServicePool(b *testing.B) {
+	rows := make([][]byte, 1000)
+	for i := range rows {
+		rows[i] = []byte(`{"id":1}`)
+	}
+	b.Run("New", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s := NewBulkService(nil)
+			s.rows = append(s.rows, rows...)
+		}
+	})
+	b.Run("Pool", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s := getBulkService(nil)
+			s.rows = append(s.rows, rows...)
+			putBulkService(s)
+		}
+	})
+}
@@ -0,0 +1,1137 @@
+package dorisloader
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBulkServiceLabelExists(t *testing.T) {
+	for _, tt := range []struct {
+		existing string
+		ok       bool
+	}{
+		{BULK_JOB_STATUS_FINISHED, true},
+		{LOAD_STATE_VISIBLE, true},
+		{"RUNNING", false},
+	} {
+		ts := newTestServer(t, func(load testLoad) interface{} {
+			return &BulkResponse{Status: BULK_STATUS_LABEL_EXISTS, ExistingJobStatus: tt.existing}
+		})
+		s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Label("l1").Add([]byte("a"))
+
+		_, err := s.Do(context.Background())
+		var serr *BulkStatusError
+		if tt.ok && err != nil {
+			t.Errorf("existing job %s: err = %v, want success", tt.existing, err)
+		} else if !tt.ok && !errors.As(err, &serr) {
+			t.Errorf("existing job %s: err = %v, want *BulkStatusError", tt.existing, err)
+		}
+	}
+}
+
+func TestBulkServiceDoChunkedRetry(t *testing.T) {
+	var mu sync.Mutex
+	loaded := map[string]string{} // label -> body
+	failed := false
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		label := load.Header.Get(BULK_HEADER_LABEL_KEY)
+		if _, ok := loaded[label]; ok {
+			return &BulkResponse{Status: BULK_STATUS_LABEL_EXISTS, ExistingJobStatus: BULK_JOB_STATUS_FINISHED, Label: label}
+		}
+		if label == "base_1" && !failed {
+			failed = true
+			return &BulkResponse{Status: BULK_STATUS_FAIL, Label: label}
+		}
+		loaded[label] = load.Body
+		return successResponse(load)
+	})
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Label("base")
+	s.Add([]byte("aaaa"), []byte("bbbb"), []byte("cccc"))
+
+	if _, err := s.DoChunked(context.Background(), 10); err == nil {
+		t.Fatal("first DoChunked succeeded, want error")
+	}
+	if _, err := s.DoChunked(context.Background(), 10); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+
+	want := map[string]string{"base_0": "aaaa\nbbbb\n", "base_1": "cccc\n"}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Errorf("loaded = %q, want %q", loaded, want)
+	}
+}
+
+func TestBulkServiceLoadFromFollowsRedirect(t *testing.T) {
+	be := newTestServer(t, successResponse)
+	fe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, be.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer fe.Close()
+	c, err := NewClient(fe.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress int64
+	// Hide the type of the reader from net/http
+	r := struct{ io.ReadSeeker }{strings.NewReader("skip\na\nb\n")}
+	r.Seek(5, io.SeekStart)
+	s := NewBulkService(c).DB("db").Table("tbl").OnProgress(func(n int64) { progress = n })
+	if _, err := s.LoadFrom(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	loads := be.Loads()
+	if len(loads) != 1 || loads[0].Body != "a\nb\n" {
+		t.Fatalf("loads = %+v, want one with body %q", loads, "a\nb\n")
+	}
+	if progress != 4 {
+		t.Errorf("progress = %d, want 4", progress)
+	}
+}
+
+func TestBulkServiceLoadFromGzipStream(t *testing.T) {
+	be := newTestServer(t, successResponse)
+	fe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, be.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer fe.Close()
+
+	content := strings.Repeat("a,b,c\n", 1<<16)
+	for _, tt := range []struct {
+		name string
+		url  string
+		body io.Reader
+	}{
+		// Hide the type of the readers from net/http
+		{"redirect", fe.URL, struct{ io.ReadSeeker }{strings.NewReader(content)}},
+		{"stream", be.URL, io.MultiReader(strings.NewReader(content))},
+	} {
+		c, err := NewClient(tt.url, SetGzip(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := len(be.Loads())
+		if _, err := NewBulkService(c).DB("db").Table("tbl").LoadFrom(context.Background(), tt.body); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		loads := be.Loads()[before:]
+		if len(loads) != 1 || loads[0].Header.Get("Content-Encoding") != "gzip" {
+			t.Fatalf("%s: loads = %d, want one gzipped", tt.name, len(loads))
+		}
+		zr, err := gzip.NewReader(strings.NewReader(loads[0].Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != content {
+			t.Errorf("%s: got %d bytes after decompression, want %d", tt.name, len(body), len(content))
+		}
+	}
+}
+
+func TestBulkServicePollVisibleDefaultTimeout(t *testing.T) {
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		if strings.HasSuffix(load.Path, "/get_load_state") {
+			return &FEResponse{Msg: "success", Data: []byte(`"VISIBLE"`)}
+		}
+		return &BulkResponse{Status: BULK_STATUS_PUBLISH_TIMEOUT, Label: load.Header.Get(BULK_HEADER_LABEL_KEY)}
+	})
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Label("l").
+		PublishTimeoutPolicy(PublishTimeoutPollVisible)
+	s.Add([]byte("a"))
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if loads := ts.Loads(); len(loads) != 2 || loads[1].Path != "/api/db/get_load_state" {
+		t.Errorf("loads = %+v, want a load and a poll of the load state", loads)
+	}
+}
+
+func TestBulkServiceDoChunkedSize(t *testing.T) {
+	for _, stripOuterArray := range []bool{false, true} {
+		ts := newTestServer(t, successResponse)
+		s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Format(BULK_FORMAT_JSON).StripOuterArray(stripOuterArray)
+		for i := 0; i < 10; i++ {
+			s.Add([]byte(strings.Repeat("a", 10)))
+		}
+		if _, err := s.DoChunked(context.Background(), 50); err != nil {
+			t.Fatal(err)
+		}
+
+		var sizes []int
+		for _, load := range ts.Loads() {
+			sizes = append(sizes, len(load.Body))
+		}
+		want := []int{44, 44, 22} // 4, 4 and 2 rows
+		if stripOuterArray {
+			want = []int{45, 45, 23}
+		}
+		if !reflect.DeepEqual(sizes, want) {
+			t.Errorf("stripOuterArray=%v: body sizes = %v, want %v", stripOuterArray, sizes, want)
+		}
+	}
+}
+
+func TestBulkServiceBuildRequest(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Label("l").Format(BULK_FORMAT_JSON)
+	s.Add([]byte(`{"a":1}`), []byte(`{"a":2}`))
+
+	opt, err := s.BuildRequest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Method != "PUT" || opt.Path != "/api/db/tbl/_stream_load" {
+		t.Errorf("request = %s %s, want PUT /api/db/tbl/_stream_load", opt.Method, opt.Path)
+	}
+	if want := "{\"a\":1}\n{\"a\":2}\n"; opt.Body != want {
+		t.Errorf("body = %q, want %q", opt.Body, want)
+	}
+	if opt.ContentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", opt.ContentType)
+	}
+	if opt.Headers.Get(BULK_HEADER_LABEL_KEY) != "l" || opt.Headers.Get(BULK_HEADER_FORMAT_KEY) != BULK_FORMAT_JSON {
+		t.Errorf("headers = %v, want label l and format json", opt.Headers)
+	}
+	if s.NumberOfRows() != 2 || len(ts.Loads()) != 0 {
+		t.Errorf("BuildRequest sent the request or cleared the rows")
+	}
+}
+
+func TestBulkServiceControlCharSeparators(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		s              *BulkService
+		sep, lineDelim string
+	}{
+		{"printable", NewBulkService(nil).ColumnSeparator("|").LineDelimiter("\\n"), "|", "\\n"},
+		{"control", NewBulkService(nil).ColumnSeparator("\x01").LineDelimiter("\x02\n"), `\x01`, `\x020a`},
+		{"hex", NewBulkService(nil).HexColumnSeparator([]byte{','}), `\x2c`, ""},
+	} {
+		if got := tt.s.headers.Get(BULK_HEADER_COLUMN_SEPARATOR_KEY); got != tt.sep {
+			t.Errorf("%s: column separator = %q, want %q", tt.name, got, tt.sep)
+		}
+		if got := tt.s.headers.Get(BULK_HEADER_LINE_DELIMITER_KEY); got != tt.lineDelim {
+			t.Errorf("%s: line delimiter = %q, want %q", tt.name, got, tt.lineDelim)
+		}
+	}
+}
+
+func TestBulkServiceValidateJSON(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	s := NewBulkService(c).DB("db").Table("tbl").Format(BULK_FORMAT_JSON).ValidateJSON(true)
+	s.Add([]byte(`{"a":1}`))
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatalf("valid row: %v", err)
+	}
+
+	s.Add([]byte(`{"a":1}`), []byte(`{"a":`))
+	_, err := s.Do(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "row 1") {
+		t.Errorf("invalid row: err = %v, want an error for row 1", err)
+	}
+	if n := len(ts.Loads()); n != 1 {
+		t.Errorf("got %d loads, want only the valid one", n)
+	}
+}
+
+func TestBulkServiceBackendHost(t *testing.T) {
+	be := newTestServer(t, successResponse)
+	fe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, be.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer fe.Close()
+	c, err := NewClient(fe.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewBulkService(c).DB("db").Table("tbl")
+	s.Add([]byte("a"))
+	res, err := s.Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimPrefix(be.URL, "http://"); res.BackendHost != want {
+		t.Errorf("BackendHost = %q, want %q", res.BackendHost, want)
+	}
+}
+
+func TestBulkServiceSentBytes(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+	row := []byte(strings.Repeat("a", 100))
+
+	var sent []int64
+	for _, compress := range []bool{false, true} {
+		s := NewBulkService(c).DB("db").Table("tbl").Compress(compress)
+		for i := 0; i < 10; i++ {
+			s.Add(row)
+		}
+		res, err := s.Do(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, res.SentBytes)
+	}
+
+	if sent[0] != 10*101 {
+		t.Errorf("SentBytes without gzip = %d, want %d", sent[0], 10*101)
+	}
+	if sent[1] <= 0 || sent[1] >= sent[0] {
+		t.Errorf("SentBytes with gzip = %d, want less than %d", sent[1], sent[0])
+	}
+}
+
+func TestBulkServiceDoChunked(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Label("l")
+	for i := 0; i < 10; i++ {
+		s.Add([]byte(strconv.Itoa(i)))
+	}
+
+	results, err := s.DoChunked(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("got %d responses, want 5", len(results))
+	}
+	for i, res := range results {
+		if want := SubLabel("l", i); res.Label != want {
+			t.Errorf("label of chunk %d = %q, want %q", i, res.Label, want)
+		}
+	}
+	if s.NumberOfRows() != 0 {
+		t.Errorf("%d rows left, want none", s.NumberOfRows())
+	}
+}
+
+func TestBulkResponseLargeLoadBytes(t *testing.T) {
+	var res BulkResponse
+	if err := new(DefaultDecoder).Decode([]byte(`{"LoadBytes":5000000000,"NumberTotalRows":3000000000}`), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.LoadBytes != 5000000000 || res.NumberTotalRows != 3000000000 {
+		t.Errorf("LoadBytes = %d, NumberTotalRows = %d, want 5000000000 and 3000000000", res.LoadBytes, res.NumberTotalRows)
+	}
+}
+
+func TestBulkServiceValidateJSONFirstInvalidRow(t *testing.T) {
+	s := NewBulkService(nil).DB("db").Table("tbl").Format(BULK_FORMAT_JSON).ValidateJSON(true)
+	s.Add([]byte(`{"a":1}`), []byte(`{"a":2}`), []byte(`{"a":3`), []byte(`{"a":4}`), []byte(`oops`))
+
+	_, err := s.PeekBody()
+	if err == nil || err.Error() != "row 2 is not valid JSON" {
+		t.Errorf("err = %v, want row 2 is not valid JSON", err)
+	}
+}
+
+func TestBulkServiceLoadFromWithChecksum(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl")
+
+	if _, err := s.LoadFromWithChecksum(context.Background(), strings.NewReader("a\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+	loads := ts.Loads()
+	if len(loads) != 1 {
+		t.Fatalf("got %d loads, want 1", len(loads))
+	}
+	if got, want := loads[0].Header.Get("Content-MD5"), "3YxqOVtd02xW0jJ1Ao9SbA=="; got != want {
+		t.Errorf("Content-MD5 = %q, want %q", got, want)
+	}
+	if loads[0].Body != "a\nb\n" {
+		t.Errorf("body = %q, want the whole content", loads[0].Body)
+	}
+}
+
+func TestBulkServiceStripOuterArrayBody(t *testing.T) {
+	s := NewBulkService(nil).Format(BULK_FORMAT_JSON).StripOuterArray(true)
+	s.Add([]byte(`{"a":1}`), []byte(`{"a":2}`))
+
+	body, err := s.PeekBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]int
+	if err := json.Unmarshal([]byte(body), &rows); err != nil {
+		t.Fatalf("body %q is not a JSON array: %v", body, err)
+	}
+	if len(rows) != 2 || rows[1]["a"] != 2 {
+		t.Errorf("rows = %v, want both rows", rows)
+	}
+}
+
+func TestBulkServiceDoWithLabel(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Label("l")
+	before := s.headers.Clone()
+
+	s.Add([]byte("a"))
+	if _, err := s.DoWithLabel(context.Background(), "override"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ts.Loads()[0].Header.Get(BULK_HEADER_LABEL_KEY); got != "override" {
+		t.Errorf("label sent = %q, want override", got)
+	}
+	if !reflect.DeepEqual(s.headers, before) {
+		t.Errorf("headers = %v, want them unchanged: %v", s.headers, before)
+	}
+}
+
+func TestBulkServiceSQL(t *testing.T) {
+	const query = "INSERT INTO db.tbl SELECT * FROM http_stream(\"format\" = \"json\")"
+	c, err := NewClient("http://fehost:8030")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewBulkService(c).SQL(query)
+	s.Add([]byte(`{"a":1}`))
+
+	opt, err := s.BuildRequest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Path != "/api/_http_stream" {
+		t.Errorf("path = %q, want /api/_http_stream", opt.Path)
+	}
+	if opt.Headers.Get(BULK_HEADER_SQL_KEY) != query {
+		t.Errorf("sql header = %q, want %q", opt.Headers.Get(BULK_HEADER_SQL_KEY), query)
+	}
+
+	if err := NewBulkService(nil).SQL(query).Columns("a").Validate(); err == nil {
+		t.Error("SQL with Columns is valid, want error")
+	}
+	if err := NewBulkService(nil).SQL(query).Where("a > 1").Validate(); err == nil {
+		t.Error("SQL with Where is valid, want error")
+	}
+}
+
+func TestBulkServiceLabelTwice(t *testing.T) {
+	s := NewBulkService(nil).Label("first").Label("second")
+	if got := s.headers.Values(BULK_HEADER_LABEL_KEY); !reflect.DeepEqual(got, []string{"second"}) {
+		t.Errorf("label headers = %q, want [second]", got)
+	}
+}
+
+func TestBulkServiceHeadersMerge(t *testing.T) {
+	s := NewBulkService(nil).Label("l").Headers(http.Header{"Columns": {"a,b"}})
+	if s.headers.Get("Expect") != "100-continue" || s.headers.Get(BULK_HEADER_LABEL_KEY) != "l" || s.headers.Get("Columns") != "a,b" {
+		t.Errorf("headers = %v, want Expect, label and columns", s.headers)
+	}
+
+	s.ReplaceHeaders(http.Header{"Columns": {"c"}})
+	if s.headers.Get("Expect") != "" || s.headers.Get("Columns") != "c" {
+		t.Errorf("headers = %v, want only columns", s.headers)
+	}
+}
+
+func TestBulkServicePublishTimeoutThenVisible(t *testing.T) {
+	var mu sync.Mutex
+	var polls int
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		if strings.HasSuffix(load.Path, "/get_load_state") {
+			mu.Lock()
+			defer mu.Unlock()
+			polls++
+			if polls == 1 {
+				return &FEResponse{Msg: "success", Data: []byte(`"COMMITTED"`)}
+			}
+			return &FEResponse{Msg: "success", Data: []byte(`"VISIBLE"`)}
+		}
+		return &BulkResponse{Status: BULK_STATUS_PUBLISH_TIMEOUT, Label: load.Header.Get(BULK_HEADER_LABEL_KEY)}
+	})
+	c := newTestClient(t, ts)
+
+	s := NewBulkService(c).DB("db").Table("tbl").Label("l")
+	s.Add([]byte("a"))
+	var serr *BulkStatusError
+	if _, err := s.Do(context.Background()); !errors.As(err, &serr) {
+		t.Fatalf("err = %v, want a BulkStatusError by default", err)
+	}
+
+	s.WaitForVisible(5 * time.Second)
+	res, err := s.Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IsPublishTimeout() || polls != 2 {
+		t.Errorf("status = %q after %d polls, want publish timeout after 2", res.Status, polls)
+	}
+}
+
+func TestBulkServiceCopyRows(t *testing.T) {
+	row := []byte("abc")
+	s := NewBulkService(nil).Add(row)
+	row[0] = 'x'
+	if body, _ := s.PeekBody(); body != "abc\n" {
+		t.Errorf("body = %q after changing the added row, want the copy abc", body)
+	}
+
+	row = []byte("abc")
+	s = NewBulkService(nil).CopyRows(false).Add(row)
+	row[0] = 'x'
+	if body, _ := s.PeekBody(); body != "xbc\n" {
+		t.Errorf("body = %q without copying, want the changed row xbc", body)
+	}
+}
+
+func TestBulkServiceContentType(t *testing.T) {
+	c, err := NewClient("http://fehost:8030")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		s    *BulkService
+		want string
+	}{
+		{NewBulkService(c), "text/plain"},
+		{NewBulkService(c).Format(BULK_FORMAT_CSV), "text/plain"},
+		{NewBulkService(c).Format(BULK_FORMAT_JSON), "application/json"},
+		{NewBulkService(c).Format(BULK_FORMAT_JSON).ContentType("application/x-ndjson"), "application/x-ndjson"},
+	} {
+		opt, err := tt.s.DB("db").Table("tbl").Add([]byte("a")).BuildRequest(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opt.ContentType != tt.want {
+			t.Errorf("format %q: Content-Type = %q, want %q", tt.s.format, opt.ContentType, tt.want)
+		}
+	}
+}
+
+func TestBulkServiceTimeoutDeadline(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	c, err := NewClient("http://fehost:8030", SetHttpClient(doerFunc(func(req *http.Request) (*http.Response, error) {
+		deadline, hasDeadline = req.Context().Deadline()
+		return jsonResponse(req, &BulkResponse{Status: BULK_STATUS_SUCCESS}), nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	s := NewBulkService(c).DB("db").Table("tbl").Timeout(60).TimeoutMargin(10 * time.Second)
+	s.Add([]byte("a"))
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !hasDeadline {
+		t.Fatal("request has no deadline")
+	}
+	if d := deadline.Sub(start); d < 70*time.Second || d > 71*time.Second {
+		t.Errorf("deadline in %v, want the 60s load timeout plus the 10s margin", d)
+	}
+}
+
+func TestBulkServiceResetAllEqualsNew(t *testing.T) {
+	s := NewBulkService(nil).DB("db").Table("tbl").Label("l").Format(BULK_FORMAT_JSON).
+		StripOuterArray(true).Timeout(10).Compress(true).MaxFilterRatio(0.1)
+	s.ResetAll()
+	s.rows = nil
+
+	if want := NewBulkService(nil); !reflect.DeepEqual(s, want) {
+		t.Errorf("ResetAll = %+v, want %+v", s, want)
+	}
+}
+
+func TestBulkServiceCompressMinBytes(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Compress(true).CompressMinBytes(100)
+
+	s.Add([]byte("small"))
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Add([]byte(strings.Repeat("large", 100)))
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	loads := ts.Loads()
+	if got := loads[0].Header.Get("Content-Encoding"); got != "" || loads[0].Body != "small\n" {
+		t.Errorf("small body sent with Content-Encoding %q, want plain", got)
+	}
+	if got := loads[1].Header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("large body sent with Content-Encoding %q, want gzip", got)
+	}
+}
+
+func TestBulkServicePublishTimeoutPolicies(t *testing.T) {
+	for _, tt := range []struct {
+		policy  PublishTimeoutPolicy
+		state   string
+		wantErr bool
+	}{
+		{PublishTimeoutError, LOAD_STATE_VISIBLE, true},
+		{PublishTimeoutSucceed, LOAD_STATE_COMMITTED, false},
+		{PublishTimeoutPollVisible, LOAD_STATE_VISIBLE, false},
+		{PublishTimeoutPollVisible, LOAD_STATE_ABORTED, true},
+	} {
+		ts := newTestServer(t, func(load testLoad) interface{} {
+			if strings.HasSuffix(load.Path, "/get_load_state") {
+				return &FEResponse{Msg: "success", Data: []byte(strconv.Quote(tt.state))}
+			}
+			return &BulkResponse{Status: BULK_STATUS_PUBLISH_TIMEOUT, Label: load.Header.Get(BULK_HEADER_LABEL_KEY)}
+		})
+		s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").Label("l").PublishTimeoutPolicy(tt.policy)
+		s.Add([]byte("a"))
+		if _, err := s.Do(context.Background()); (err != nil) != tt.wantErr {
+			t.Errorf("policy %d with state %s: err = %v, want error %v", tt.policy, tt.state, err, tt.wantErr)
+		}
+	}
+}
+
+type testBase struct {
+	ID      int64  `json:"id"`
+	Created string `json:"created_at,omitempty"`
+}
+
+type testRow struct {
+	testBase
+	Name     string `json:"name"`
+	Internal string `json:"-"`
+	Score    float64
+	hidden   int
+}
+
+func TestBulkServiceColumnsFromStruct(t *testing.T) {
+	s := NewBulkService(nil).ColumnsFromStruct(&testRow{})
+	if got, want := s.headers.Get(BULK_HEADER_COLUMNS_KEY), "id,created_at,name,Score"; got != want {
+		t.Errorf("columns = %q, want %q", got, want)
+	}
+}
+
+func TestBulkServiceOption(t *testing.T) {
+	s := NewBulkService(nil).DB("db").Table("tbl").Option("memtable_on_sink_node", "true")
+	if got := s.headers.Get("memtable_on_sink_node"); got != "true" {
+		t.Errorf("memtable_on_sink_node = %q, want true", got)
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	for _, s := range []*BulkService{
+		NewBulkService(nil).DB("db").Table("tbl").Option("x", "a\r\nlabel: injected"),
+		NewBulkService(nil).DB("db").Table("tbl").Option("x: y", "a"),
+		NewBulkService(nil).DB("db").Table("tbl").Option("", "a"),
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("invalid option accepted: %v", s.headers)
+		}
+	}
+}
+
+func TestBulkServiceGroupCommit(t *testing.T) {
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		// A group commit response has no TxnId
+		return json.RawMessage(`{"Status":"Success","GroupCommit":true,"Label":"group_commit_a1","NumberLoadedRows":1}`)
+	})
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").GroupCommit(BULK_GROUP_COMMIT_ASYNC)
+	s.Add([]byte("a"))
+
+	res, err := s.Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.GroupCommit || res.TxnID != 0 || res.NumberLoadedRows != 1 {
+		t.Errorf("response = %+v, want a group commit without txn id", res)
+	}
+	if got := ts.Loads()[0].Header.Get(BULK_HEADER_GROUP_COMMIT_KEY); got != BULK_GROUP_COMMIT_ASYNC {
+		t.Errorf("group_commit = %q, want %q", got, BULK_GROUP_COMMIT_ASYNC)
+	}
+
+	if err := NewBulkService(nil).DB("db").Table("tbl").GroupCommit("fast_mode").Validate(); err == nil {
+		t.Error("unknown group commit mode accepted")
+	}
+}
+
+func TestBulkServiceLongLabel(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+	long := strings.Repeat("l", bulkMaxLabelLength+1)
+
+	s := NewBulkService(c).DB("db").Table("tbl").Label(long)
+	s.Add([]byte("a"))
+	if _, err := s.Do(context.Background()); err == nil {
+		t.Fatal("too long label accepted")
+	}
+	if len(ts.Loads()) != 0 {
+		t.Fatal("request with a too long label sent")
+	}
+
+	s.TruncateLabel(true)
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	label := ts.Loads()[0].Header.Get(BULK_HEADER_LABEL_KEY)
+	if len(label) != bulkMaxLabelLength || !strings.HasPrefix(label, "lll") {
+		t.Errorf("label = %q, want it truncated to %d characters", label, bulkMaxLabelLength)
+	}
+	if again, _ := s.checkLabel(long); again != label {
+		t.Errorf("truncated label %q differs from %q, want it deterministic", again, label)
+	}
+
+	if err := NewBulkService(c).DB("db").Table("tbl").Label("a/b").Validate(); err == nil {
+		t.Error("label with '/' accepted")
+	}
+}
+
+func TestBulkServiceCSVOptions(t *testing.T) {
+	s := NewBulkService(nil).DB("db").Table("tbl").CSV(CSVOptions{
+		Separator:        "\x01",
+		LineDelimiter:    "\n",
+		Enclose:          `"`,
+		Escape:           `\`,
+		TrimDoubleQuotes: true,
+		WithNames:        true,
+	})
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		BULK_HEADER_FORMAT_KEY:             BULK_FORMAT_CSV_WITH_NAMES,
+		BULK_HEADER_COLUMN_SEPARATOR_KEY:   `\x01`,
+		BULK_HEADER_LINE_DELIMITER_KEY:     `\x0a`,
+		BULK_HEADER_ENCLOSE_KEY:            `"`,
+		BULK_HEADER_ESCAPE_KEY:             `\`,
+		BULK_HEADER_TRIM_DOUBLE_QUOTES_KEY: "true",
+		BULK_HEADER_SKIP_LINES_KEY:         "",
+	}
+	for key, value := range want {
+		if got := s.headers.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	for _, opts := range []CSVOptions{
+		{Enclose: `""`},
+		{Escape: `\`},
+		{SkipLines: -1},
+		{WithNames: true, SkipLines: 1},
+	} {
+		if err := NewBulkService(nil).DB("db").Table("tbl").CSV(opts).Validate(); err == nil {
+			t.Errorf("invalid options %+v accepted", opts)
+		}
+	}
+}
+
+func TestBulkServiceJSONOptions(t *testing.T) {
+	s := NewBulkService(nil).DB("db").Table("tbl").JSON(JSONOptions{
+		JsonPaths:       `["$.id","$.name"]`,
+		JsonRoot:        "$.data",
+		StripOuterArray: true,
+		NumAsString:     true,
+		FuzzyParse:      true,
+	})
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		BULK_HEADER_FORMAT_KEY:            BULK_FORMAT_JSON,
+		BULK_HEADER_JSONPATHS_KEY:         `["$.id","$.name"]`,
+		BULK_HEADER_JSON_ROOT_KEY:         "$.data",
+		BULK_HEADER_STRIP_OUTER_ARRAY_KEY: "true",
+		BULK_HEADER_NUM_AS_STRING_KEY:     "true",
+		BULK_HEADER_FUZZY_PARSE_KEY:       "true",
+		BULK_HEADER_READ_JSON_BY_LINE_KEY: "",
+	}
+	for key, value := range want {
+		if got := s.headers.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	for _, opts := range []JSONOptions{
+		{StripOuterArray: true, ReadJsonByLine: true},
+		{FuzzyParse: true},
+	} {
+		if err := NewBulkService(nil).DB("db").Table("tbl").JSON(opts).Validate(); err == nil {
+			t.Errorf("invalid options %+v accepted", opts)
+		}
+	}
+}
+
+func TestBulkServiceLoadFromParquet(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	s := NewBulkService(newTestClient(t, ts, SetGzip(true))).DB("db").Table("tbl").Format(BULK_FORMAT_PARQUET)
+
+	blob := "PAR1\x15\x04\x15\x10\x15\x14L\x00PAR1"
+	if _, err := s.LoadFrom(context.Background(), strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	loads := ts.Loads()
+	if len(loads) != 1 {
+		t.Fatalf("got %d loads, want 1", len(loads))
+	}
+	if loads[0].Body != blob {
+		t.Errorf("body = %q, want %q", loads[0].Body, blob)
+	}
+	if got := loads[0].Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if got := loads[0].Header.Get("Content-Type"); got != "application/octet-stream" {
+		t.Errorf("Content-Type = %q, want application/octet-stream", got)
+	}
+	if got := loads[0].Header.Get(BULK_HEADER_FORMAT_KEY); got != BULK_FORMAT_PARQUET {
+		t.Errorf("format = %q, want %q", got, BULK_FORMAT_PARQUET)
+	}
+}
+
+func TestBulkServiceValidate(t *testing.T) {
+	valid := func() *BulkService { return NewBulkService(nil).DB("db").Table("tbl") }
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid service: %v", err)
+	}
+
+	for name, s := range map[string]*BulkService{
+		"no table":                    NewBulkService(nil).DB("db"),
+		"max filter ratio":            valid().MaxFilterRatio(1.5),
+		"sql with columns":            valid().SQL("INSERT INTO db.tbl SELECT * FROM http_stream()").Columns("a"),
+		"strip outer array csv":       valid().StripOuterArray(true),
+		"jsonpaths csv":               valid().Header(BULK_HEADER_JSONPATHS_KEY, `["$.a"]`),
+		"merge without delete":        valid().Header(BULK_HEADER_MERGE_TYPE_KEY, "MERGE"),
+		"delete without merge":        valid().Header(BULK_HEADER_DELETE_KEY, "a=1"),
+		"partial columns w/o columns": valid().Header(BULK_HEADER_PARTIAL_COLUMNS_KEY, "true"),
+		"group commit with label":     valid().Label("l1").GroupCommit(BULK_GROUP_COMMIT_ASYNC),
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: invalid combination accepted", name)
+		}
+	}
+
+	// Do fails fast without sending anything
+	ts := newTestServer(t, successResponse)
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").MaxFilterRatio(-1)
+	s.Add([]byte("a"))
+	if _, err := s.Do(context.Background()); err == nil {
+		t.Error("Do accepted an invalid service")
+	}
+	if n := len(ts.Loads()); n != 0 {
+		t.Errorf("got %d loads, want none", n)
+	}
+}
+
+func TestBulkServiceOnProgress(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+
+	var (
+		mu       sync.Mutex
+		reported []int64
+	)
+	s := NewBulkService(newTestClient(t, ts)).DB("db").Table("tbl").
+		OnProgress(func(bytesRead int64) {
+			mu.Lock()
+			reported = append(reported, bytesRead)
+			mu.Unlock()
+		})
+
+	// Hide the Seeker of the reader so progress is never reset
+	const size = 3*progressReaderInterval + 512
+	body := io.MultiReader(strings.NewReader(strings.Repeat("a\n", size/2)))
+	if _, err := s.LoadFrom(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) < 3 {
+		t.Fatalf("got %d progress reports, want at least 3", len(reported))
+	}
+	for i := 1; i < len(reported); i++ {
+		if reported[i] <= reported[i-1] {
+			t.Errorf("progress %v is not monotonically increasing", reported)
+			break
+		}
+	}
+	if last := reported[len(reported)-1]; last != size {
+		t.Errorf("last progress = %d, want %d", last, size)
+	}
+}
+
+func TestBulkResponsePredicates(t *testing.T) {
+	for _, tt := range []struct {
+		res                                  BulkResponse
+		success, publishTimeout, labelExists bool
+		filterRatio                          float64
+	}{
+		{res: BulkResponse{Status: BULK_STATUS_SUCCESS, NumberTotalRows: 4, NumberFilteredRows: 1}, success: true, filterRatio: 0.25},
+		{res: BulkResponse{Status: BULK_STATUS_PUBLISH_TIMEOUT, NumberTotalRows: 2}, publishTimeout: true},
+		{res: BulkResponse{Status: BULK_STATUS_LABEL_EXISTS, ExistingJobStatus: BULK_JOB_STATUS_FINISHED}, labelExists: true},
+		{res: BulkResponse{Status: BULK_STATUS_FAIL, NumberTotalRows: 2, NumberFilteredRows: 2}, filterRatio: 1},
+		{res: BulkResponse{Status: BULK_STATUS_FAIL}},
+	} {
+		r := &tt.res
+		if r.IsSuccess() != tt.success || r.IsPublishTimeout() != tt.publishTimeout || r.IsLabelExists() != tt.labelExists {
+			t.Errorf("%s: IsSuccess = %v, IsPublishTimeout = %v, IsLabelExists = %v, want %v, %v, %v",
+				r.Status, r.IsSuccess(), r.IsPublishTimeout(), r.IsLabelExists(), tt.success, tt.publishTimeout, tt.labelExists)
+		}
+		if got := r.FilterRatio(); got != tt.filterRatio {
+			t.Errorf("%s: FilterRatio = %v, want %v", r.Status, got, tt.filterRatio)
+		}
+	}
+}
+
+func TestBulkServiceMaxFilterRatioPrecision(t *testing.T) {
+	for _, ratio := range []float64{0, 0.0001, 0.25, 1} {
+		s := NewBulkService(nil).DB("db").Table("tbl").MaxFilterRatio(ratio)
+		if err := s.Validate(); err != nil {
+			t.Errorf("%v: %v", ratio, err)
+		}
+		got, err := strconv.ParseFloat(s.headers.Get(BULK_HEADER_MAX_FILTER_RATIO_KEY), 64)
+		if err != nil || got != ratio {
+			t.Errorf("max_filter_ratio = %q, want %v", s.headers.Get(BULK_HEADER_MAX_FILTER_RATIO_KEY), ratio)
+		}
+	}
+	if got := NewBulkService(nil).MaxFilterRatio(0.0001).headers.Get(BULK_HEADER_MAX_FILTER_RATIO_KEY); got != "0.0001" {
+		t.Errorf("max_filter_ratio = %q, want 0.0001", got)
+	}
+}
+
+func TestBulkServiceRowsIsACopy(t *testing.T) {
+	s := NewBulkService(nil)
+	s.Add([]byte("a"), []byte("b"))
+
+	rows := s.Rows()
+	rows[0][0] = 'x'
+	rows[1] = []byte("y")
+	rows = append(rows, []byte("z"))
+	if got := s.Rows(); !reflect.DeepEqual(got, [][]byte{[]byte("a"), []byte("b")}) {
+		t.Errorf("Rows = %q after mutating the copy, want [a b]", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		body, err := s.PeekBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "a\nb\n" {
+			t.Errorf("PeekBody = %q, want %q", body, "a\nb\n")
+		}
+	}
+	if n := s.NumberOfRows(); n != 2 {
+		t.Errorf("%d rows after PeekBody, want 2", n)
+	}
+}
+
+func TestBulkServiceSuccessStatuses(t *testing.T) {
+	status := "OK"
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		return &BulkResponse{Status: status}
+	})
+
+	do := func(c *Client, statuses []string) error {
+		s := NewBulkService(c).DB("db").Table("tbl")
+		if statuses != nil {
+			s.SuccessStatuses(statuses)
+		}
+		s.Add([]byte("a"))
+		_, err := s.Do(context.Background())
+		return err
+	}
+
+	c := newTestClient(t, ts)
+	var statusErr *BulkStatusError
+	if err := do(c, nil); !errors.As(err, &statusErr) {
+		t.Errorf("OK with the default statuses: err = %v, want a *BulkStatusError", err)
+	}
+	if err := do(c, []string{"OK"}); err != nil {
+		t.Errorf("OK with a custom service set: %v", err)
+	}
+	if err := do(newTestClient(t, ts, SetSuccessStatuses([]string{"OK"})), nil); err != nil {
+		t.Errorf("OK with a custom client set: %v", err)
+	}
+
+	status = BULK_STATUS_SUCCESS
+	if err := do(c, []string{"OK"}); !errors.As(err, &statusErr) {
+		t.Errorf("Success outside the custom set: err = %v, want a *BulkStatusError", err)
+	}
+}
+
+func TestBulkServiceComment(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	for _, comment := range []string{"pipeline=orders run=42", ""} {
+		s := NewBulkService(c).DB("db").Table("tbl").Comment("previous").Comment(comment)
+		s.Add([]byte("a"))
+		if _, err := s.Do(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loads := ts.Loads()
+	if got := loads[0].Header.Get(BULK_HEADER_COMMENT_KEY); got != "pipeline=orders run=42" {
+		t.Errorf("comment = %q, want pipeline=orders run=42", got)
+	}
+	if _, ok := loads[1].Header[http.CanonicalHeaderKey(BULK_HEADER_COMMENT_KEY)]; ok {
+		t.Errorf("empty comment sent as %q", loads[1].Header.Get(BULK_HEADER_COMMENT_KEY))
+	}
+
+	if err := NewBulkService(c).DB("db").Table("tbl").Comment("a\r\nb").Validate(); err == nil {
+		t.Error("comment with a line break accepted")
+	}
+}
+
+func TestBulkServiceMethod(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	for _, method := range []string{"", http.MethodPost} {
+		s := NewBulkService(c).DB("db").Table("tbl").Method(method)
+		s.Add([]byte("a"))
+		if _, err := s.Do(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.LoadFrom(context.Background(), strings.NewReader("b\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var methods []string
+	for _, load := range ts.Loads() {
+		methods = append(methods, load.Method)
+	}
+	if want := []string{"PUT", "PUT", "POST", "POST"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("methods = %v, want %v", methods, want)
+	}
+}
+
+func TestBulkServiceIdempotencyKeyFromBody(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	s := NewBulkService(c).DB("db").Table("tbl").IdempotencyKeyFromBody(true)
+	for _, rows := range []string{"a", "a", "b"} {
+		s.Add([]byte(rows))
+		if _, err := s.Do(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.IdempotencyKeyHeader("X-Dedup-Key").IdempotencyKey("explicit")
+	s.Add([]byte("a"))
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	loads := ts.Loads()
+	first, second, third := loads[0].Header.Get("Idempotency-Key"), loads[1].Header.Get("Idempotency-Key"), loads[2].Header.Get("Idempotency-Key")
+	if first != BodyIdempotencyKey("a\n") || first != second {
+		t.Errorf("keys of identical rows = %q and %q, want both %q", first, second, BodyIdempotencyKey("a\n"))
+	}
+	if third == first {
+		t.Errorf("different rows share the key %q", third)
+	}
+	if got := loads[3].Header.Get("X-Dedup-Key"); got != "explicit" || loads[3].Header.Get("Idempotency-Key") != "" {
+		t.Errorf("X-Dedup-Key = %q, want only the explicit key", got)
+	}
+}
+
+func TestBulkServiceDoChunkedDeterministicLabels(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	run := func() {
+		s := NewBulkService(c).DB("db").Table("tbl").Label("batch_7")
+		for i := 0; i < 6; i++ {
+			s.Add([]byte(strconv.Itoa(i)))
+		}
+		if _, err := s.DoChunked(context.Background(), 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run()
+	run()
+
+	var labels []string
+	for _, load := range ts.Loads() {
+		labels = append(labels, load.Header.Get(BULK_HEADER_LABEL_KEY))
+	}
+	want := []string{"batch_7_0", "batch_7_1", "batch_7_2", "batch_7_0", "batch_7_1", "batch_7_2"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("labels = %q, want %q", labels, want)
+	}
+}
+
+func TestBulkServiceMaxRowSize(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	s := NewBulkService(c).DB("db").Table("tbl").MaxRowSize(4)
+	s.Add([]byte("abcd"), []byte("abcde"), []byte("x"))
+	if n := s.NumberOfRows(); n != 2 {
+		t.Errorf("%d rows buffered, want the 2 within the limit", n)
+	}
+	if _, err := s.Do(context.Background()); err == nil || !strings.Contains(err.Error(), "exceeds max row size of 4") {
+		t.Errorf("err = %v, want a max row size error", err)
+	}
+	if n := len(ts.Loads()); n != 0 {
+		t.Errorf("got %d loads, want the whole batch to fail", n)
+	}
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil).MaxRowSize(4)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.TryAdd([]byte("abcde")); err == nil {
+		t.Error("processor accepted a row over the limit")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ts.Loads()); n != 0 {
+		t.Errorf("got %d loads, want none", n)
+	}
+}
+
+func TestBulkServiceGroupCommitLabelConflict(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	for _, mode := range []string{BULK_GROUP_COMMIT_ASYNC, BULK_GROUP_COMMIT_SYNC} {
+		s := NewBulkService(c).DB("db").Table("tbl").GroupCommit(mode).Label("l1")
+		s.Add([]byte("a"))
+		if _, err := s.Do(context.Background()); err == nil {
+			t.Errorf("%s: Do with a label succeeded, want error", mode)
+		}
+		s = NewBulkService(c).DB("db").Table("tbl").GroupCommit(mode)
+		s.Add([]byte("a"))
+		if _, err := s.DoWithLabel(context.Background(), "l2"); err == nil {
+			t.Errorf("%s: DoWithLabel succeeded, want error", mode)
+		}
+	}
+	if n := len(ts.Loads()); n != 0 {
+		t.Errorf("got %d loads, want none", n)
+	}
+
+	// Labels are fine with group commit off
+	s := NewBulkService(c).DB("db").Table("tbl").GroupCommit(BULK_GROUP_COMMIT_OFF).Label("l3")
+	s.Add([]byte("a"))
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	load := ts.Loads()[0]
+	if load.Header.Get(BULK_HEADER_GROUP_COMMIT_KEY) != BULK_GROUP_COMMIT_OFF || load.Header.Get(BULK_HEADER_LABEL_KEY) != "l3" {
+		t.Errorf("headers = %v, want off_mode and label l3", load.Header)
+	}
+}
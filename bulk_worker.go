@@ -2,6 +2,10 @@ package dorisloader
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
 )
 
 type bulkWorker struct {
@@ -9,6 +13,7 @@ type bulkWorker struct {
 	i           int
 	bulkActions int
 	bulkSize    int
+	maxBytes    int
 	service     *BulkService
 	flushC      chan struct{}
 	flushAckC   chan struct{}
@@ -16,15 +21,23 @@ type bulkWorker struct {
 
 // newBulkWorker creates a new bulkWorker instance.
 func newBulkWorker(p *BulkProcessor, i int) *bulkWorker {
-	return &bulkWorker{
+	w := &bulkWorker{
 		p:           p,
 		i:           i,
 		bulkActions: p.bulkActions,
 		bulkSize:    p.bulkSize,
+		maxBytes:    p.maxBytesPerRequest,
 		service:     NewBulkService(p.c).DB(p.db).Table(p.table),
 		flushC:      make(chan struct{}),
 		flushAckC:   make(chan struct{}),
 	}
+	// Keep the rows of a full batch for reuse, even beyond the default.
+	// As a batch is committed once it has bulkActions rows, they fit.
+	if w.bulkActions > bulkMaxRetainedRows {
+		w.service.maxRetainedRows = w.bulkActions
+		w.service.rows = make([][]byte, 0, w.bulkActions)
+	}
+	return w
 }
 
 // work waits for bulk requests and manual flush calls on the respective
@@ -37,20 +50,59 @@ func (w *bulkWorker) work(ctx context.Context) {
 		close(w.flushC)
 	}()
 
+	// idle fires when no row has been added for idleFlushInterval
+	var idle Timer
+	var idleC <-chan time.Time
+	if w.p.idleFlushInterval > 0 {
+		idle = w.p.clock.NewTimer(w.p.idleFlushInterval)
+		idle.Stop()
+		defer idle.Stop()
+	}
+
 	var stop bool
 	for !stop {
 		var err error
 		select {
 		case row, open := <-w.p.rows:
-			if open {
-				w.service.Add(row)
-				if w.commitRequired() {
+			if open && atomic.LoadInt32(&w.p.discarding) == 1 {
+				// Closing with discard: drop the row
+			} else if open && ctx.Err() != nil {
+				// Nothing can be committed anymore: report the row instead
+				// of piling it up
+				w.drop(row, ctx.Err())
+			} else if open {
+				if w.commitRequiredBefore(row) {
 					err = w.commit(ctx)
 				}
+				if err != nil && w.service.NumberOfRows() > 0 {
+					// The pending rows were kept, and row doesn't fit
+					w.drop(row, err)
+				} else {
+					w.service.Add(row)
+					if err == nil && w.commitRequired() {
+						err = w.commit(ctx)
+					}
+				}
+				if idle != nil {
+					if !idle.Stop() {
+						select {
+						case <-idle.C():
+						default:
+						}
+					}
+					idle.Reset(w.p.idleFlushInterval)
+					idleC = idle.C()
+				}
 			} else {
 				// Channel closed: Stop.
 				stop = true
-				if w.service.NumberOfRows() > 0 {
+				if atomic.LoadInt32(&w.p.discarding) == 1 {
+					w.service.Reset()
+				} else if w.service.NumberOfRows() > 0 && ctx.Err() != nil {
+					// The rows kept while ctx is done can't be committed
+					err = ctx.Err()
+					w.dropPending(err)
+				} else if w.service.NumberOfRows() > 0 {
 					err = w.commit(ctx)
 				}
 			}
@@ -60,6 +112,12 @@ func (w *bulkWorker) work(ctx context.Context) {
 				err = w.commit(ctx)
 			}
 			w.flushAckC <- struct{}{}
+		case <-idleC:
+			// No rows added for a while: commit outstanding requests
+			idleC = nil
+			if w.service.NumberOfRows() > 0 {
+				err = w.commit(ctx)
+			}
 		}
 		if err != nil {
 			if !stop {
@@ -70,17 +128,66 @@ func (w *bulkWorker) work(ctx context.Context) {
 }
 
 // commit commits the bulk requests in the given service,
-// invoking callbacks as specified.
+// invoking callbacks as specified. Rows that fail to commit are kept,
+// unless DropFailedBatches is set and ctx is not done; then they are
+// dropped and reported in the BulkEvent.
 func (w *bulkWorker) commit(ctx context.Context) error {
 
-	//var res *BulkResponse
+	var res *BulkResponse
+
+	id := atomic.AddInt64(&w.p.executionId, 1)
+
+	// Don't send a request that is bound to fail. The rows stay in the
+	// service, and the failure is reported like any other.
+	if err := ctx.Err(); err != nil {
+		w.p.updateStats(nil, err)
+		w.p.emit(BulkEvent{Worker: w.i, ExecutionId: id, Label: w.service.label, Err: err})
+		return err
+	}
+
+	prevLabel := w.service.label
+	if w.p.labelPrefixFunc != nil {
+		w.service.Label(fmt.Sprintf("%s_%s_%d_%d", w.p.labelPrefixFunc(), w.p.startNonce, w.i, id))
+	}
+
+	label := w.service.label
+	if w.p.labelStore != nil && label != "" {
+		if err := w.p.labelStore.Reserve(label); err != nil {
+			// Keep the rows for the next commit, which gets a new label
+			w.service.Label(prevLabel)
+			w.p.updateStats(nil, err)
+			w.p.emit(BulkEvent{Worker: w.i, ExecutionId: id, Label: label, Err: err})
+			return err
+		}
+	}
 
 	// commitFunc will commit bulk requests and, on failure, be retried
 	// via exponential backoff
 	commitFunc := func() error {
 		var err error
+		if l := w.p.rateLimiter; l != nil {
+			if err := l.wait(ctx, w.service.EstimatedSizeInBytes()); err != nil {
+				return err
+			}
+		}
+		if sem := w.p.requestSem; sem != nil {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
 		// Save requests because they will be reset in service.Do
-		_, err = w.service.Do(ctx)
+		start := w.p.clock.Now()
+		res, err = w.service.Do(ctx)
+		w.p.recordCommitTime(w.p.clock.Now().Sub(start))
+		var serr *BulkStatusError
+		if errors.As(err, &serr) && serr.Response.Status == BULK_STATUS_FAIL {
+			// Rejected by Doris, e.g. for data quality; sending it again
+			// won't help
+			return Permanent(err)
+		}
 		if err != nil {
 			return err
 		}
@@ -88,20 +195,51 @@ func (w *bulkWorker) commit(ctx context.Context) error {
 	}
 
 	// notifyFunc will be called if retry fails
-	notifyFunc := func(err error) {
-		// TODO
+	var attempt int
+	notifyFunc := func(err error, wait time.Duration) {
+		attempt++
+		if w.p.retryFunc != nil {
+			w.p.retryFunc(id, attempt, err, wait)
+		}
 	}
 
 	// Commit bulk requests
-	err := RetryNotify(commitFunc, w.p.backoff, notifyFunc)
-	if err != nil {
-		// TODO
+	err := RetryNotifyWithContext(ctx, commitFunc, w.p.backoff, notifyFunc)
+
+	// Drop the failed batch if asked to, unless it failed because ctx is done
+	var dropped [][]byte
+	if err != nil && w.p.dropFailedBatches && ctx.Err() == nil {
+		dropped = append(dropped, w.service.rows...)
+		w.service.Reset()
+		w.p.recordDropped(len(dropped))
+	}
+
+	if w.p.labelStore != nil && label != "" {
+		state := LabelCommitted
+		if err != nil {
+			state = LabelFailed
+		}
+		if merr := w.p.labelStore.Mark(label, state); merr != nil && err == nil {
+			err = merr
+		}
+	}
+
+	w.p.updateStats(res, err)
+
+	e := BulkEvent{Worker: w.i, ExecutionId: id, Label: w.service.label, Err: err, Rows: dropped}
+	if res != nil {
+		e.TxnId = res.TxnID
+		e.Label = res.Label
 	}
+	w.p.emit(e)
 
 	return err
 }
 
 func (w *bulkWorker) commitRequired() bool {
+	if w.p.flushOnlyOnInterval {
+		return false
+	}
 	if w.bulkActions > 0 && w.service.NumberOfRows() >= w.bulkActions {
 		return true
 	}
@@ -110,3 +248,29 @@ func (w *bulkWorker) commitRequired() bool {
 	}
 	return false
 }
+
+// commitRequiredBefore reports whether the pending rows must be committed
+// before row is added, so that the body, delimiters included, stays
+// within maxBytes.
+func (w *bulkWorker) commitRequiredBefore(row []byte) bool {
+	if w.maxBytes <= 0 || w.service.NumberOfRows() == 0 {
+		return false
+	}
+	size := w.service.EstimatedSizeInBytes() + w.service.estimateSizeInBytes(row)
+	return w.service.renderedSizeInBytes(size, w.service.NumberOfRows()+1) > int64(w.maxBytes)
+}
+
+// drop reports row as dropped without committing it because of err.
+func (w *bulkWorker) drop(row []byte, err error) {
+	w.p.recordDropped(1)
+	w.p.emit(BulkEvent{Worker: w.i, Err: err, Rows: [][]byte{row}})
+}
+
+// dropPending reports the pending rows as dropped without committing
+// them because of err, and removes them.
+func (w *bulkWorker) dropPending(err error) {
+	e := BulkEvent{Worker: w.i, Label: w.service.label, Err: err, Rows: append([][]byte(nil), w.service.rows...)}
+	w.service.Reset()
+	w.p.recordDropped(len(e.Rows))
+	w.p.emit(e)
+}
@@ -0,0 +1,485 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBulkProcessorDropsFailedBatch(t *testing.T) {
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		if strings.Contains(load.Body, "bad") {
+			return &BulkResponse{Status: BULK_STATUS_FAIL, Message: "too many filtered rows"}
+		}
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil).
+		EnableEvents(10, true).
+		DropFailedBatches(true)
+	events := p.Events()
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []string{"bad", "good1", "good2"} {
+		p.Add([]byte(row))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var bodies []string
+	for _, load := range ts.Loads() {
+		bodies = append(bodies, load.Body)
+	}
+	if want := []string{"bad\n", "good1\n", "good2\n"}; !reflect.DeepEqual(bodies, want) {
+		t.Fatalf("bodies = %q, want %q", bodies, want)
+	}
+
+	var dropped [][]byte
+	for e := range events {
+		if e.Err != nil {
+			dropped = append(dropped, e.Rows...)
+		}
+	}
+	if want := [][]byte{[]byte("bad")}; !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped rows = %q, want %q", dropped, want)
+	}
+	stats := p.Stats()
+	if stats.Succeeded != 2 || stats.Failed != 1 || stats.DroppedRows != 1 {
+		t.Errorf("stats = %+v, want 2 succeeded, 1 failed, 1 dropped row", stats)
+	}
+}
+
+func TestBulkProcessorKeepsFailedBatch(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			return &BulkResponse{Status: BULK_STATUS_FAIL, Message: "too many filtered rows"}
+		}
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p.Add([]byte("a"))
+	p.Add([]byte("b"))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var bodies []string
+	for _, load := range ts.Loads() {
+		bodies = append(bodies, load.Body)
+	}
+	if want := []string{"a\n", "a\nb\n"}; !reflect.DeepEqual(bodies, want) {
+		t.Fatalf("bodies = %q, want %q", bodies, want)
+	}
+	if n := p.Stats().DroppedRows; n != 0 {
+		t.Errorf("DroppedRows = %d, want 0", n)
+	}
+}
+
+func TestBulkProcessorReportsRowsAfterCancel(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 100, 0, 0, NewConstantBackoff(0), nil).EnableEvents(10, true)
+	events := p.Events()
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p.Add([]byte("a"))
+	p.Add([]byte("b"))
+	cancel()
+	// Rows added while ctx is done are reported right away
+	p.Add([]byte("c"))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(ts.Loads()); n != 0 {
+		t.Errorf("got %d loads, want none", n)
+	}
+	var dropped []string
+	for e := range events {
+		if !errors.Is(e.Err, context.Canceled) {
+			t.Errorf("event error = %v, want context.Canceled", e.Err)
+		}
+		for _, row := range e.Rows {
+			dropped = append(dropped, string(row))
+		}
+	}
+	sort.Strings(dropped)
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped rows = %q, want %q", dropped, want)
+	}
+	if n := p.Stats().DroppedRows; n != 3 {
+		t.Errorf("DroppedRows = %d, want 3", n)
+	}
+}
+
+func TestBulkProcessorMaxBytesPerRequest(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 100, 0, 0, NewConstantBackoff(0), nil).MaxBytesPerRequest(20)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.TryAdd([]byte(strings.Repeat("x", 20))); err == nil {
+		t.Error("TryAdd of a 20 byte row succeeded, want error")
+	}
+	p.Add([]byte(strings.Repeat("x", 20)))
+	if n := p.Stats().DroppedRows; n != 1 {
+		t.Errorf("DroppedRows = %d after Add of a 20 byte row, want 1", n)
+	}
+	for i := 0; i < 3; i++ {
+		p.Add([]byte(strings.Repeat("a", 10)))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loads := ts.Loads()
+	if len(loads) != 3 {
+		t.Fatalf("got %d loads, want 3", len(loads))
+	}
+	for _, load := range loads {
+		if len(load.Body) > 20 {
+			t.Errorf("body of %d bytes exceeds the cap of 20", len(load.Body))
+		}
+	}
+}
+
+func TestBulkWorkerRetainsRows(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	const bulkActions = 2 * bulkMaxRetainedRows
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, bulkActions, 0, 0, NewConstantBackoff(0), nil)
+	w := newBulkWorker(p, 0)
+	for i := 0; i < bulkActions; i++ {
+		w.service.Add([]byte("a"))
+	}
+	rows := &w.service.rows[0]
+	if _, err := w.service.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	w.service.Add([]byte("b"))
+	if &w.service.rows[0] != rows {
+		t.Error("rows of a full batch were not reused")
+	}
+}
+
+func TestBulkProcessorRetryFunc(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls <= 2 {
+			return testStatus(http.StatusInternalServerError)
+		}
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	var attempts []int
+	var waits []time.Duration
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(time.Millisecond), nil).
+		RetryFunc(func(executionId int64, attempt int, err error, wait time.Duration) {
+			attempts = append(attempts, attempt)
+			waits = append(waits, wait)
+		})
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p.Add([]byte("a"))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Errorf("attempts = %v, want [1 2]", attempts)
+	}
+	if !reflect.DeepEqual(waits, []time.Duration{time.Millisecond, time.Millisecond}) {
+		t.Errorf("waits = %v, want 1ms each", waits)
+	}
+}
+
+func TestBulkProcessorMaxConcurrentRequests(t *testing.T) {
+	const limit = 2
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+		requests            int
+	)
+	c, err := NewClient("http://fehost:8030", SetHttpClient(doerFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		inFlight++
+		requests++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mu.Unlock()
+
+		// Block long enough for the other workers to pile up
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return jsonResponse(req, &BulkResponse{Status: BULK_STATUS_SUCCESS}), nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 6, 1, 0, 0, NewConstantBackoff(0), nil).
+		MaxConcurrentRequests(limit)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 24; i++ {
+		p.Add([]byte(strconv.Itoa(i)))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 24 {
+		t.Errorf("got %d requests, want 24", requests)
+	}
+	if maxFlight > limit {
+		t.Errorf("%d requests in flight, want at most %d", maxFlight, limit)
+	}
+}
+
+func TestBulkProcessorIdleFlushInterval(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 100, 0, 0, NewConstantBackoff(0), nil).
+		IdleFlushInterval(50 * time.Millisecond)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	start := time.Now()
+	p.Add([]byte("a"))
+	for len(ts.Loads()) == 0 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the idle row was never committed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("committed after %v, before the idle period", elapsed)
+	}
+	if loads := ts.Loads(); len(loads) != 1 || loads[0].Body != "a\n" {
+		t.Errorf("loads = %+v, want the single row", loads)
+	}
+}
+
+func TestBulkProcessorDiscardOnClose(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 2, 100, 0, 0, NewConstantBackoff(0), nil).
+		DiscardOnClose(true)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		p.Add([]byte(strconv.Itoa(i)))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ts.Loads()); n != 0 {
+		t.Errorf("got %d loads, want none", n)
+	}
+}
+
+func TestBulkProcessorMaxConcurrentRequestsBlocking(t *testing.T) {
+	entered := make(chan struct{}, 8)
+	release := make(chan struct{})
+	c, err := NewClient("http://fehost:8030", SetHttpClient(doerFunc(func(req *http.Request) (*http.Response, error) {
+		entered <- struct{}{}
+		<-release
+		return jsonResponse(req, &BulkResponse{Status: BULK_STATUS_SUCCESS}), nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 4, 1, 0, 0, NewConstantBackoff(0), nil).
+		MaxConcurrentRequests(1)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		p.Add([]byte(strconv.Itoa(i)))
+	}
+
+	<-entered
+	select {
+	case <-entered:
+		t.Error("a second commit started while the first is blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBulkProcessorRateLimit(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	const (
+		rate  = 50000 // bytes per second
+		rows  = 100
+		total = rows * 1000
+	)
+	p := NewBulkProcessor(c, "test", "db", "tbl", 4, 1, 0, 0, NewConstantBackoff(0), nil).
+		RateLimit(rate)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	row := []byte(strings.Repeat("a", 999))
+	for i := 0; i < rows; i++ {
+		p.Add(row)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	var sent int
+	for _, load := range ts.Loads() {
+		sent += len(load.Body)
+	}
+	if sent != total {
+		t.Fatalf("sent %d bytes, want %d", sent, total)
+	}
+	// The bucket starts full, so one second worth of bytes goes out at once
+	if min := time.Duration(float64(total-rate) / rate * float64(time.Second)); elapsed < min*9/10 {
+		t.Errorf("sent %d bytes in %v, want at least %v at %d bytes/s", total, elapsed, min, rate)
+	}
+}
+
+func TestBulkWorkerCommitCanceledContext(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 10, 0, 0, NewConstantBackoff(0), nil)
+	w := newBulkWorker(p, 0)
+	w.service.Add([]byte("a"), []byte("b"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.commit(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if n := len(ts.Loads()); n != 0 {
+		t.Errorf("got %d loads, want none", n)
+	}
+	if n := w.service.NumberOfRows(); n != 2 {
+		t.Errorf("%d rows left after the canceled commit, want 2", n)
+	}
+	if stats := p.Stats(); stats.Failed != 1 || stats.DroppedRows != 0 {
+		t.Errorf("stats = %+v, want one failed commit and no dropped rows", stats)
+	}
+
+	// The rows are committed with the next live context
+	if err := w.commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if loads := ts.Loads(); len(loads) != 1 || loads[0].Body != "a\nb\n" {
+		t.Errorf("loads = %+v, want both rows", loads)
+	}
+}
+
+func TestBulkWorkerReleasesLargeBatch(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	// Without bulk actions, a flush can commit any number of rows
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 0, 0, time.Hour, NewConstantBackoff(0), nil)
+	w := newBulkWorker(p, 0)
+	for i := 0; i < 2*bulkMaxRetainedRows; i++ {
+		w.service.Add([]byte("a"))
+	}
+	if err := w.commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c := cap(w.service.rows); c != 0 {
+		t.Errorf("cap(rows) = %d after a large batch, want 0", c)
+	}
+
+	// Small batches keep their backing array
+	w.service.Add([]byte("a"), []byte("b"))
+	if err := w.commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c := cap(w.service.rows); c == 0 {
+		t.Error("rows of a small batch were released")
+	}
+}
+
+func TestBulkWorkerCommitCanceledMidRetry(t *testing.T) {
+	attempted := make(chan struct{}, 1)
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		select {
+		case attempted <- struct{}{}:
+		default:
+		}
+		return testStatus(http.StatusServiceUnavailable)
+	})
+	c := newTestClient(t, ts)
+
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 10, 0, 0, NewConstantBackoff(time.Minute), nil)
+	w := newBulkWorker(p, 0)
+	w.service.Add([]byte("a"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-attempted
+		cancel()
+	}()
+	start := time.Now()
+	err := w.commit(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("commit returned after %v, want promptly", elapsed)
+	}
+	if n := len(ts.Loads()); n != 1 {
+		t.Errorf("got %d attempts, want 1", n)
+	}
+}
@@ -0,0 +1,126 @@
+package dorisloader
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by PerformRequest if the circuit breaker of
+// the FE node is open, i.e. the node failed too often recently.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreakerState is the state of the circuit breaker of a node.
+type CircuitBreakerState int
+
+const (
+	// CircuitClosed lets requests through.
+	CircuitClosed CircuitBreakerState = iota
+	// CircuitOpen rejects requests with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe request through.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker tracks consecutive failures of a node. After threshold
+// failures it opens and rejects requests. After resetTimeout it lets a
+// single probe request through (half-open), which closes it on success
+// and opens it again on failure.
+type circuitBreaker struct {
+	threshold    int
+	resetTimeout time.Duration
+
+	mu       sync.Mutex // guards the next block
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, resetTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, resetTimeout: resetTimeout}
+}
+
+// state returns the current state of the breaker.
+func (b *circuitBreaker) state() CircuitBreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return CircuitClosed
+	}
+	if b.probing || time.Since(b.openedAt) >= b.resetTimeout {
+		return CircuitHalfOpen
+	}
+	return CircuitOpen
+}
+
+// available reports whether allow would let a request through, without
+// starting a probe.
+func (b *circuitBreaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.open || !b.probing && time.Since(b.openedAt) >= b.resetTimeout
+}
+
+// allow returns ErrCircuitOpen if a request must not be sent.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.resetTimeout {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// success records a successful request and closes the breaker.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.open = false
+	b.probing = false
+}
+
+// failure records a failed request and opens the breaker if the threshold
+// is reached or the probe failed.
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.open = true
+		b.openedAt = time.Now()
+	}
+	b.probing = false
+}
+
+// release records a request whose outcome says nothing about the node,
+// e.g. one that was canceled, so that another probe may be sent.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
@@ -0,0 +1,92 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	var requests int32
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			return testStatus(http.StatusInternalServerError)
+		}
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts, SetCircuitBreaker(2, 50*time.Millisecond))
+	get := func() error {
+		_, err := c.PerformRequest(context.Background(), PerformRequestOptions{Method: "GET", Path: "/"})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, ok := AsHTTPError(get()); !ok {
+			t.Fatalf("request %d didn't fail with an HTTPError", i)
+		}
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("server got %d requests, want 2", n)
+	}
+
+	// After the reset timeout, a probe gets through and closes the breaker
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := get(); err != nil {
+			t.Errorf("request %d after recovery: %v", i, err)
+		}
+	}
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+	steps := []struct {
+		do   func()
+		want CircuitBreakerState
+	}{
+		{func() {}, CircuitClosed},
+		{b.failure, CircuitClosed},
+		{b.failure, CircuitOpen},
+		{func() { time.Sleep(30 * time.Millisecond) }, CircuitHalfOpen},
+		{func() {
+			if err := b.allow(); err != nil {
+				t.Errorf("probe rejected: %v", err)
+			}
+			if err := b.allow(); err != ErrCircuitOpen {
+				t.Errorf("second probe: err = %v, want ErrCircuitOpen", err)
+			}
+		}, CircuitHalfOpen},
+		{b.failure, CircuitOpen},
+		{func() { time.Sleep(30 * time.Millisecond); b.allow() }, CircuitHalfOpen},
+		{b.success, CircuitClosed},
+	}
+	for i, step := range steps {
+		step.do()
+		if got := b.state(); got != step.want {
+			t.Fatalf("step %d: state = %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func TestClientCircuitBreakerStates(t *testing.T) {
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		return testStatus(http.StatusServiceUnavailable)
+	})
+	c := newTestClient(t, ts, SetCircuitBreaker(1, time.Minute))
+	if states := c.CircuitBreakerStates(); len(states) != 0 {
+		t.Errorf("states = %v before any request, want none", states)
+	}
+	c.PerformRequest(context.Background(), PerformRequestOptions{Method: "GET", Path: "/"})
+	if states := c.CircuitBreakerStates(); states[ts.URL] != CircuitOpen {
+		t.Errorf("states = %v, want %s open", states, ts.URL)
+	}
+}
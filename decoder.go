@@ -1,6 +1,9 @@
 package dorisloader
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+)
 
 // Decoder is used to decode responses from Elasticsearch.
 // Users of elastic can implement their own marshaler for advanced purposes
@@ -18,3 +21,15 @@ type DefaultDecoder struct{}
 func (u *DefaultDecoder) Decode(data []byte, v interface{}) error {
 	return json.Unmarshal(data, v)
 }
+
+// NumberDecoder uses json.Decoder with UseNumber, so numbers decoded into
+// interface{} values are kept as json.Number instead of float64 and do not
+// lose precision.
+type NumberDecoder struct{}
+
+// Decode decodes with json.Decoder and UseNumber.
+func (u *NumberDecoder) Decode(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -0,0 +1,57 @@
+package dorisloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// failingDecoder is a Decoder that fails to decode anything.
+type failingDecoder struct{}
+
+func (failingDecoder) Decode(data []byte, v interface{}) error {
+	return errors.New("failing decoder")
+}
+
+func TestNumberDecoder(t *testing.T) {
+	data := []byte(`{"TxnId":9007199254740993,"LoadBytes":9007199254740993}`)
+
+	var m map[string]interface{}
+	if err := new(NumberDecoder).Decode(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := m["LoadBytes"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Errorf("LoadBytes = %#v, want json.Number 9007199254740993", m["LoadBytes"])
+	}
+
+	var res BulkResponse
+	if err := new(NumberDecoder).Decode(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.LoadBytes != 9007199254740993 || res.TxnID != 9007199254740993 {
+		t.Errorf("LoadBytes = %d, TxnID = %d, want 9007199254740993", res.LoadBytes, res.TxnID)
+	}
+}
+
+func TestBulkServiceDecoderOverridesClient(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts, SetDecoder(failingDecoder{}))
+
+	s := NewBulkService(c).DB("db").Table("tbl").Decoder(new(NumberDecoder))
+	s.Add([]byte("a"))
+	res, err := s.Do(context.Background())
+	if err != nil {
+		t.Fatalf("per-request decoder: %v", err)
+	}
+	if res.Status != BULK_STATUS_SUCCESS {
+		t.Errorf("Status = %q, want %q", res.Status, BULK_STATUS_SUCCESS)
+	}
+
+	// Without an override the decoder of the client is used
+	s = NewBulkService(c).DB("db").Table("tbl")
+	s.Add([]byte("a"))
+	if _, err := s.Do(context.Background()); err == nil || err.Error() != "failing decoder" {
+		t.Errorf("client decoder: err = %v, want failing decoder", err)
+	}
+}
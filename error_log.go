@@ -0,0 +1,113 @@
+package dorisloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+)
+
+// RowError describes a row rejected by a load, as reported in its error log.
+type RowError struct {
+	Index  int    // index of the row in the batch, -1 if it could not be determined
+	Row    []byte // the row as added, or as reported in the error log if Index is -1
+	Reason string // reason the row was rejected
+}
+
+// FetchErrorLog returns the content of the error log at errorURL, e.g.
+// the ErrorURL of a BulkResponse. The request is sent like any other of
+// the client, e.g. with its user agent and credentials.
+func (c *Client) FetchErrorLog(ctx context.Context, errorURL string) (string, error) {
+	opt := PerformRequestOptions{
+		Method: "GET",
+		URL:    errorURL,
+	}
+	res, err := c.PerformRequest(ctx, opt)
+	if err != nil {
+		return "", err
+	}
+	return string(res.Body), nil
+}
+
+// ErrorLogEntry is an entry of the error log of a load.
+type ErrorLogEntry struct {
+	Line   int    // line of the entry in the error log, starting at 1
+	Reason string // reason the row was rejected
+	Raw    string // the rejected source row, empty if not reported
+}
+
+// ParseErrorLog parses the error log of a load, as returned by
+// FetchErrorLog. Entries look like
+// "Reason: column count mismatch. src line [a,b,c]; ". Lines that are
+// not entries, e.g. trailing data, are skipped. It returns an error if
+// the log is not empty but holds no entries.
+func ParseErrorLog(body string) ([]ErrorLogEntry, error) {
+	var entries []ErrorLogEntry
+	var nonEmpty bool
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		nonEmpty = true
+		if !strings.HasPrefix(line, "Reason:") && !strings.Contains(line, "src line [") {
+			continue
+		}
+		reason, raw := splitErrorLogLine(line)
+		entries = append(entries, ErrorLogEntry{Line: i + 1, Reason: reason, Raw: raw})
+	}
+	if nonEmpty && len(entries) == 0 {
+		return nil, errors.New("error log holds no entries")
+	}
+	return entries, nil
+}
+
+// rowErrors maps the entries of the error log to the given rows, by
+// comparing the source row reported for each entry with the rows.
+func rowErrors(log string, rows [][]byte) []RowError {
+	entries, _ := ParseErrorLog(log)
+	errs := make([]RowError, 0, len(entries))
+	for _, entry := range entries {
+		errs = append(errs, matchRowError(entry.Raw, entry.Reason, rows))
+	}
+	return errs
+}
+
+// inlineRowErrors maps the rejected records returned in a response to
+// the given rows. The response does not report reasons.
+func inlineRowErrors(records []string, rows [][]byte) []RowError {
+	errs := make([]RowError, 0, len(records))
+	for _, record := range records {
+		errs = append(errs, matchRowError(strings.TrimSpace(record), "", rows))
+	}
+	return errs
+}
+
+// matchRowError returns a RowError for the rejected raw row, with the
+// index of the equal row in rows, if any.
+func matchRowError(raw, reason string, rows [][]byte) RowError {
+	e := RowError{Index: -1, Row: []byte(raw), Reason: reason}
+	for i, row := range rows {
+		if raw != "" && bytes.Equal(bytes.TrimSpace(row), []byte(raw)) {
+			e.Index, e.Row = i, row
+			break
+		}
+	}
+	return e
+}
+
+// splitErrorLogLine splits an entry of an error log into the reason and
+// the source row.
+func splitErrorLogLine(line string) (reason, src string) {
+	reason = line
+	if i := strings.Index(line, "src line ["); i >= 0 {
+		reason = line[:i]
+		src = line[i+len("src line ["):]
+		if j := strings.LastIndex(src, "]"); j >= 0 {
+			src = src[:j]
+		}
+	}
+	reason = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(reason), "Reason:"))
+	reason = strings.TrimSuffix(reason, ".")
+	return strings.TrimSpace(reason), strings.TrimSpace(src)
+}
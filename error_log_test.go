@@ -0,0 +1,149 @@
+package dorisloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestBulkServiceTrackRowErrors(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error_log" {
+			fmt.Fprint(w, "Reason: column count mismatch. src line [b,2,x]; \n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&BulkResponse{
+			Status:             BULK_STATUS_SUCCESS,
+			NumberFilteredRows: 1,
+			ErrorURL:           ts.URL + "/error_log",
+		})
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewBulkService(c).DB("db").Table("tbl").MaxFilterRatio(0.5).TrackRowErrors(true)
+	s.Add([]byte("a,1"), []byte("b,2,x"), []byte("c,3"))
+	res, err := s.Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RowError{{Index: 1, Row: []byte("b,2,x"), Reason: "column count mismatch"}}
+	if !reflect.DeepEqual(res.RowErrors, want) {
+		t.Errorf("RowErrors = %+v, want %+v", res.RowErrors, want)
+	}
+
+	// Row errors are opt-in
+	s.TrackRowErrors(false)
+	s.Add([]byte("b,2,x"))
+	if res, err = s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res.RowErrors != nil {
+		t.Errorf("RowErrors = %+v without TrackRowErrors, want none", res.RowErrors)
+	}
+}
+
+func TestFetchErrorLogUsesClientSettings(t *testing.T) {
+	const body = "Reason: column count mismatch. src line [b,2,x]; \n"
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL, SetUserAgent("loader/1.0"), SetHost("fe.example"), SetBasicAuth("user", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := c.FetchErrorLog(context.Background(), ts.URL+"/api/_load_error_log?file=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log != body {
+		t.Errorf("log = %q, want %q", log, body)
+	}
+	if ua := got.Header.Get("User-Agent"); ua != "loader/1.0" {
+		t.Errorf("User-Agent = %q, want loader/1.0", ua)
+	}
+	if got.Host != "fe.example" {
+		t.Errorf("Host = %q, want fe.example", got.Host)
+	}
+	if user, pass, ok := got.BasicAuth(); !ok || user != "user" || pass != "secret" {
+		t.Errorf("basic auth = %q, %q, %v, want user, secret", user, pass, ok)
+	}
+	if q := got.URL.Query().Get("file"); q != "x" {
+		t.Errorf("file = %q, want x", q)
+	}
+	if n := c.BytesReceived(); n != int64(len(body)) {
+		t.Errorf("BytesReceived = %d, want %d", n, len(body))
+	}
+}
+
+func TestParseErrorLog(t *testing.T) {
+	log := "Reason: column count mismatch. src line [a,b,c]; \n" +
+		"Reason: null value for not null column, column=id. src line [,x]; \n" +
+		"\n" +
+		"trailing data"
+	entries, err := ParseErrorLog(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ErrorLogEntry{
+		{Line: 1, Reason: "column count mismatch", Raw: "a,b,c"},
+		{Line: 2, Reason: "null value for not null column, column=id", Raw: ",x"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v, want %+v", entries, want)
+	}
+
+	if entries, err := ParseErrorLog(""); err != nil || len(entries) != 0 {
+		t.Errorf("empty log: entries = %+v, err = %v, want none", entries, err)
+	}
+	if _, err := ParseErrorLog("<html>not found</html>"); err == nil {
+		t.Error("a log without entries was accepted")
+	}
+}
+
+func TestBulkServiceInlineRejectedRecords(t *testing.T) {
+	for _, field := range []string{"RejectedRecords", "ErrorRows"} {
+		t.Run(field, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/error_log" {
+					t.Error("error log fetched although the rows were returned inline")
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"Status":"Success","NumberFilteredRows":1,"ErrorURL":"http://%s/error_log","%s":["b,2,x"]}`, r.Host, field)
+			}))
+			defer ts.Close()
+			c, err := NewClient(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := NewBulkService(c).DB("db").Table("tbl").MaxFilterRatio(0.5).TrackRowErrors(true)
+			s.Add([]byte("a,1"), []byte("b,2,x"))
+			res, err := s.Do(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.RejectedRecords, []string{"b,2,x"}) {
+				t.Errorf("RejectedRecords = %q, want [b,2,x]", res.RejectedRecords)
+			}
+			want := []RowError{{Index: 1, Row: []byte("b,2,x")}}
+			if !reflect.DeepEqual(res.RowErrors, want) {
+				t.Errorf("RowErrors = %+v, want %+v", res.RowErrors, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package dorisloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxHTTPErrorBody is the maximum number of response body bytes kept
+// in an HTTPError.
+const maxHTTPErrorBody = 4096
+
+// HTTPError is returned by PerformRequest if the server responds with
+// a status code other than 2xx that is not ignored.
+type HTTPError struct {
+	Status int         // HTTP status code, e.g. 500
+	Body   []byte      // response body, truncated to 4 KiB
+	URL    string      // url of the request
+	Header http.Header // response headers, e.g. the Location of a redirect
+}
+
+// newHTTPError creates a new HTTPError, truncating body if necessary.
+func newHTTPError(status int, body []byte, url string, header http.Header) *HTTPError {
+	if len(body) > maxHTTPErrorBody {
+		body = body[:maxHTTPErrorBody]
+	}
+	return &HTTPError{Status: status, Body: body, URL: url, Header: header}
+}
+
+func (e *HTTPError) Error() string {
+	if len(e.Body) > 0 {
+		return fmt.Sprintf("http status %d from %s: %s", e.Status, e.URL, e.Body)
+	}
+	return fmt.Sprintf("http status %d from %s", e.Status, e.URL)
+}
+
+// AsHTTPError returns the HTTPError in the chain of err, if any.
+func AsHTTPError(err error) (*HTTPError, bool) {
+	var herr *HTTPError
+	if errors.As(err, &herr) {
+		return herr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,51 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPErrorKeepsLocation(t *testing.T) {
+	fe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://be:8040"+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer fe.Close()
+	c, err := NewClient(fe.URL, SetFollowRedirects(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.PerformRequest(context.Background(), PerformRequestOptions{Method: "PUT", Path: "/api/db/tbl/_stream_load"})
+	herr, ok := AsHTTPError(err)
+	if !ok {
+		t.Fatalf("err = %v, want an HTTPError", err)
+	}
+	if want := "http://be:8040/api/db/tbl/_stream_load"; herr.Header.Get("Location") != want {
+		t.Errorf("Location = %q, want %q", herr.Header.Get("Location"), want)
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	body := []byte(strings.Repeat("x", maxHTTPErrorBody+10))
+	herr := newHTTPError(http.StatusBadGateway, body, "http://fehost:8030/api", nil)
+	if len(herr.Body) != maxHTTPErrorBody {
+		t.Errorf("body of %d bytes, want it truncated to %d", len(herr.Body), maxHTTPErrorBody)
+	}
+
+	err := fmt.Errorf("load: %w", herr)
+	got, ok := AsHTTPError(err)
+	if !ok || got != herr || got.Status != http.StatusBadGateway || got.URL != "http://fehost:8030/api" {
+		t.Errorf("AsHTTPError = %+v, %v, want the wrapped error", got, ok)
+	}
+	if !strings.HasPrefix(err.Error(), "load: http status 502 from http://fehost:8030/api: xxx") {
+		t.Errorf("message = %.60q...", err.Error())
+	}
+	if _, ok := AsHTTPError(errors.New("other")); ok {
+		t.Error("AsHTTPError found an HTTPError in an unrelated error")
+	}
+}
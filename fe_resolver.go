@@ -0,0 +1,72 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultFeURLRefreshInterval is the interval in which the FE urls of a
+// FeURLResolver are refreshed by default.
+const defaultFeURLRefreshInterval = 30 * time.Second
+
+// FeURLResolver returns the urls of the current FE nodes, e.g. from
+// service discovery.
+type FeURLResolver func(ctx context.Context) ([]string, error)
+
+// feURLCache caches the FE urls returned by a FeURLResolver and picks
+// one per request in round-robin order.
+type feURLCache struct {
+	resolver        FeURLResolver
+	refreshInterval time.Duration
+
+	mu         sync.Mutex // guards the next block
+	urls       []string
+	resolvedAt time.Time
+
+	next uint32 // index of the next url, accessed atomically
+}
+
+func newFeURLCache(resolver FeURLResolver, refreshInterval time.Duration) *feURLCache {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultFeURLRefreshInterval
+	}
+	return &feURLCache{resolver: resolver, refreshInterval: refreshInterval}
+}
+
+// pick returns the url of the FE node to send the next request to,
+// refreshing the urls if they are stale. If refreshing fails, the stale
+// urls are used, if any. Nodes for which live returns false are skipped,
+// unless all are.
+func (c *feURLCache) pick(ctx context.Context, live func(url string) bool) (string, error) {
+	c.mu.Lock()
+	if len(c.urls) == 0 || time.Since(c.resolvedAt) >= c.refreshInterval {
+		urls, err := c.resolver(ctx)
+		if err == nil && len(urls) == 0 {
+			err = errors.New("FE url resolver returned no urls")
+		}
+		if err != nil {
+			if len(c.urls) == 0 {
+				c.mu.Unlock()
+				return "", err
+			}
+		} else {
+			c.urls = urls
+		}
+		// Don't query a failing resolver on every request
+		c.resolvedAt = time.Now()
+	}
+	urls := c.urls
+	c.mu.Unlock()
+
+	i := atomic.AddUint32(&c.next, 1) - 1
+	for n := 0; n < len(urls); n++ {
+		url := urls[int((i+uint32(n))%uint32(len(urls)))]
+		if live(url) {
+			return url, nil
+		}
+	}
+	return urls[int(i%uint32(len(urls)))], nil
+}
@@ -0,0 +1,88 @@
+package dorisloader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientFeURLResolver(t *testing.T) {
+	first := newTestServer(t, successResponse)
+	second := newTestServer(t, successResponse)
+
+	var (
+		mu    sync.Mutex
+		urls  = []string{first.URL}
+		calls int
+	)
+	resolver := func(ctx context.Context) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return urls, nil
+	}
+	c, err := NewClient("http://unused:8030", SetFeURLResolver(resolver, 200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() {
+		t.Helper()
+		s := NewBulkService(c).DB("db").Table("tbl")
+		s.Add([]byte("a"))
+		if _, err := s.Do(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load()
+	load()
+	mu.Lock()
+	urls = []string{second.URL}
+	mu.Unlock()
+	// Cached until the refresh interval has passed
+	load()
+	if n := len(first.Loads()); n != 3 {
+		t.Errorf("first FE got %d loads, want 3", n)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	load()
+	if n := len(second.Loads()); n != 1 {
+		t.Errorf("second FE got %d loads, want 1", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Errorf("resolver called %d times, want 2", calls)
+	}
+}
+
+func TestFeURLCacheSkipsOpenNodes(t *testing.T) {
+	resolver := func(ctx context.Context) ([]string, error) {
+		return []string{"http://a", "http://b", "http://c"}, nil
+	}
+	c := newFeURLCache(resolver, time.Hour)
+	down := map[string]bool{"http://a": true, "http://c": true}
+	live := func(url string) bool { return !down[url] }
+	for i := 0; i < 3; i++ {
+		if got, err := c.pick(context.Background(), live); err != nil || got != "http://b" {
+			t.Errorf("pick = %s, %v with a and c down, want http://b", got, err)
+		}
+	}
+
+	// Fall back to round-robin if all are down
+	down["http://b"] = true
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		got, err := c.pick(context.Background(), live)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[got] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("picked %v with all nodes down, want all of them", seen)
+	}
+}
@@ -0,0 +1,57 @@
+package dorisloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// FEResponse is the envelope of the responses of the FE REST API, other
+// than stream load, e.g. {"code":0,"msg":"success","data":...}.
+type FEResponse struct {
+	Code  int             `json:"code"`
+	Msg   string          `json:"msg"`
+	Data  json.RawMessage `json:"data"`
+	Count int             `json:"count"`
+}
+
+// FEError is returned for a FEResponse with a code other than 0.
+type FEError struct {
+	Code int
+	Msg  string
+}
+
+func (e *FEError) Error() string {
+	return fmt.Sprintf("FE error code %d: %s", e.Code, e.Msg)
+}
+
+// AsFEError returns the FEError in the chain of err, if any.
+func AsFEError(err error) (*FEError, bool) {
+	var ferr *FEError
+	if errors.As(err, &ferr) {
+		return ferr, true
+	}
+	return nil, false
+}
+
+// DecodeFEResponse decodes the envelope of resp and then its data into
+// data, which may be nil to only check the code. It returns a *FEError if
+// the code is not 0.
+func DecodeFEResponse(resp *Response, data interface{}) error {
+	return decodeFEResponse(&DefaultDecoder{}, resp, data)
+}
+
+// decodeFEResponse is like DecodeFEResponse, using decoder.
+func decodeFEResponse(decoder Decoder, resp *Response, data interface{}) error {
+	env := new(FEResponse)
+	if err := decoder.Decode(resp.Body, env); err != nil {
+		return err
+	}
+	if env.Code != 0 {
+		return &FEError{Code: env.Code, Msg: env.Msg}
+	}
+	if data == nil || len(env.Data) == 0 || string(env.Data) == "null" {
+		return nil
+	}
+	return decoder.Decode(env.Data, data)
+}
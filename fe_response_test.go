@@ -0,0 +1,27 @@
+package dorisloader
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecodeFEResponse(t *testing.T) {
+	var state string
+	ok := &Response{Body: []byte(`{"code":0,"msg":"success","data":"VISIBLE","count":0}`)}
+	if err := DecodeFEResponse(ok, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state != "VISIBLE" {
+		t.Errorf("data = %q, want VISIBLE", state)
+	}
+
+	failed := &Response{Body: []byte(`{"code":403,"msg":"Access denied","data":null,"count":0}`)}
+	err := DecodeFEResponse(failed, &state)
+	ferr, isFE := AsFEError(fmt.Errorf("get load state: %w", err))
+	if !isFE || ferr.Code != 403 || ferr.Msg != "Access denied" {
+		t.Errorf("err = %v, want a *FEError with code 403", err)
+	}
+	if _, isFE := AsFEError(DecodeFEResponse(&Response{Body: []byte("<html>")}, nil)); isFE {
+		t.Error("a malformed body was reported as a *FEError")
+	}
+}
@@ -0,0 +1,80 @@
+package dorisloader
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// weightedFrontend is a FE node of a weightedFrontends.
+type weightedFrontend struct {
+	url     string
+	weight  int
+	current int // current weight of the smooth weighted round-robin
+}
+
+// weightedFrontends picks FE nodes in smooth weighted round-robin order,
+// as nginx does, skipping nodes whose circuit breaker is open.
+type weightedFrontends struct {
+	mu    sync.Mutex // guards nodes
+	nodes []*weightedFrontend
+}
+
+func newWeightedFrontends(weights map[string]int) (*weightedFrontends, error) {
+	if len(weights) == 0 {
+		return nil, errors.New("no FE nodes given")
+	}
+	f := &weightedFrontends{}
+	for url, weight := range weights {
+		if url == "" {
+			return nil, errors.New("FE url is empty")
+		}
+		if weight < 0 {
+			return nil, errors.New("FE weight of " + url + " must not be negative")
+		}
+		f.nodes = append(f.nodes, &weightedFrontend{url: url, weight: weight})
+	}
+	// Map order is random; make the sequence deterministic
+	sort.Slice(f.nodes, func(i, j int) bool { return f.nodes[i].url < f.nodes[j].url })
+	return f, nil
+}
+
+// pick returns the url of the FE node to send the next request to.
+// Nodes for which live returns false are skipped. If no weighted node is
+// live, any live node is used, including those with a weight of 0; if
+// none is live, the node with the highest weight is returned.
+func (f *weightedFrontends) pick(live func(url string) bool) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var best *weightedFrontend
+	total := 0
+	for _, n := range f.nodes {
+		if n.weight == 0 || !live(n.url) {
+			continue
+		}
+		n.current += n.weight
+		total += n.weight
+		if best == nil || n.current > best.current {
+			best = n
+		}
+	}
+	if best != nil {
+		best.current -= total
+		return best.url
+	}
+
+	// Fall back to any live node
+	for _, n := range f.nodes {
+		if live(n.url) {
+			return n.url
+		}
+	}
+	best = f.nodes[0]
+	for _, n := range f.nodes[1:] {
+		if n.weight > best.weight {
+			best = n
+		}
+	}
+	return best.url
+}
@@ -0,0 +1,59 @@
+package dorisloader
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClientSetFrontendsWeighted(t *testing.T) {
+	near := newTestServer(t, successResponse)
+	far := newTestServer(t, successResponse)
+	fallback := newTestServer(t, successResponse)
+
+	c, err := NewClient(near.URL, SetFrontendsWeighted(map[string]int{near.URL: 3, far.URL: 1, fallback.URL: 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const requests = 400
+	for i := 0; i < requests; i++ {
+		if _, err := c.PerformRequest(context.Background(), PerformRequestOptions{Method: "GET", Path: "/"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Smooth weighted round-robin hits the weights exactly
+	if got, want := len(near.Loads()), requests*3/4; got != want {
+		t.Errorf("near FE got %d requests, want %d", got, want)
+	}
+	if got, want := len(far.Loads()), requests/4; got != want {
+		t.Errorf("far FE got %d requests, want %d", got, want)
+	}
+	if got := len(fallback.Loads()); got != 0 {
+		t.Errorf("fallback FE got %d requests, want none", got)
+	}
+}
+
+func TestWeightedFrontendsFallback(t *testing.T) {
+	f, err := newWeightedFrontends(map[string]int{"http://a": 3, "http://b": 1, "http://c": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := map[string]bool{"http://a": true}
+	live := func(url string) bool { return !down[url] }
+	for i := 0; i < 4; i++ {
+		if got := f.pick(live); got != "http://b" {
+			t.Errorf("pick = %s with a down, want http://b", got)
+		}
+	}
+
+	down["http://b"] = true
+	if got := f.pick(live); got != "http://c" {
+		t.Errorf("pick = %s with all weighted nodes down, want the fallback http://c", got)
+	}
+
+	for _, weights := range []map[string]int{nil, {"": 1}, {"http://a": -1}} {
+		if _, err := newWeightedFrontends(weights); err == nil {
+			t.Errorf("weights %v accepted", weights)
+		}
+	}
+}
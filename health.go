@@ -0,0 +1,78 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// healthCacheTTL is the time the result of a health check is reused by
+// CachedHealth.
+const healthCacheTTL = 5 * time.Second
+
+// ErrNoBackendOnline is returned by Health if the FE reports no live BE.
+var ErrNoBackendOnline = errors.New("no backend online")
+
+// HealthStatus is the data of the health API of the FE.
+type HealthStatus struct {
+	OnlineBackendNum int `json:"online_backend_num"`
+	TotalBackendNum  int `json:"total_backend_num"`
+}
+
+// healthCache remembers the outcome of the last health check.
+type healthCache struct {
+	mu        sync.Mutex // guards the next block
+	checkedAt time.Time
+	err       error
+}
+
+// Health checks the health of the cluster with the FE. It returns
+// ErrNoBackendOnline if no BE is alive.
+func (c *Client) Health(ctx context.Context) (*HealthStatus, error) {
+	opt := PerformRequestOptions{
+		Method: "GET",
+		Path:   "/api/health",
+	}
+	res, err := c.PerformRequest(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	status := new(HealthStatus)
+	if err := decodeFEResponse(c.decoderFor(&opt), res, status); err != nil {
+		return nil, err
+	}
+	if status.OnlineBackendNum == 0 {
+		return status, ErrNoBackendOnline
+	}
+
+	return status, nil
+}
+
+// CachedHealth is like Health, but reuses the outcome of a check within
+// the last 5 seconds, e.g. to check before every load without doubling
+// the number of requests.
+func (c *Client) CachedHealth(ctx context.Context) error {
+	c.health.mu.Lock()
+	if !c.health.checkedAt.IsZero() && time.Since(c.health.checkedAt) < healthCacheTTL {
+		err := c.health.err
+		c.health.mu.Unlock()
+		return err
+	}
+	c.health.mu.Unlock()
+
+	// Don't hold the lock during the request, so that callers whose ctx
+	// is done don't wait for a slow check of another one
+	_, err := c.Health(ctx)
+	if IsContextErr(err) {
+		// Says nothing about the cluster
+		return err
+	}
+
+	c.health.mu.Lock()
+	c.health.checkedAt = time.Now()
+	c.health.err = err
+	c.health.mu.Unlock()
+	return err
+}
@@ -0,0 +1,84 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulkServicePreflightUnhealthy(t *testing.T) {
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		if load.Path == "/api/health" {
+			return map[string]interface{}{
+				"code": 0,
+				"msg":  "success",
+				"data": HealthStatus{OnlineBackendNum: 0, TotalBackendNum: 3},
+			}
+		}
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	for i := 0; i < 2; i++ {
+		s := NewBulkService(c).DB("db").Table("tbl").Preflight(true)
+		s.Add([]byte(strings.Repeat("a", 1024)))
+		if _, err := s.Do(context.Background()); !errors.Is(err, ErrNoBackendOnline) {
+			t.Errorf("err = %v, want ErrNoBackendOnline", err)
+		}
+	}
+
+	// The body is never sent and the health is checked once
+	loads := ts.Loads()
+	if len(loads) != 1 || loads[0].Path != "/api/health" {
+		t.Errorf("loads = %+v, want a single health check", loads)
+	}
+}
+
+func TestCachedHealthDoesNotBlockOnSlowCheck(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		started <- struct{}{}
+		<-release
+		return map[string]interface{}{
+			"code": 0,
+			"msg":  "success",
+			"data": HealthStatus{OnlineBackendNum: 1, TotalBackendNum: 1},
+		}
+	})
+	c := newTestClient(t, ts)
+
+	slow := make(chan error, 1)
+	go func() { slow <- c.CachedHealth(context.Background()) }()
+	<-started
+
+	// Another caller gives up with its own ctx instead of waiting for the
+	// slow check
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- c.CachedHealth(ctx) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) && !IsContextErr(err) {
+			t.Errorf("err = %v, want a deadline error", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("CachedHealth blocked on the check of another caller")
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+	// The outcome of the slow check is cached
+	if err := c.CachedHealth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The second caller's request may have reached the server, too
+	if n := len(ts.Loads()); n > 2 {
+		t.Errorf("got %d health requests, want at most 2", n)
+	}
+}
@@ -0,0 +1,186 @@
+package dorisloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLoad is a request received by a testServer.
+type testLoad struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// testStatus makes a testServer respond with the status code instead of
+// a JSON body.
+type testStatus int
+
+// testServer is a fake Doris FE that records the requests it receives
+// and answers them with respond.
+type testServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	loads []testLoad
+}
+
+// newTestServer starts a testServer answering with respond, which
+// returns the value to encode as JSON response, or a testStatus.
+func newTestServer(t *testing.T, respond func(load testLoad) interface{}) *testServer {
+	t.Helper()
+	ts := &testServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		load := testLoad{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: string(body)}
+		ts.mu.Lock()
+		ts.loads = append(ts.loads, load)
+		ts.mu.Unlock()
+		res := respond(load)
+		if code, ok := res.(testStatus); ok {
+			w.WriteHeader(int(code))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Loads returns the requests received so far.
+func (ts *testServer) Loads() []testLoad {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]testLoad(nil), ts.loads...)
+}
+
+// newTestClient returns a client for ts.
+func newTestClient(t *testing.T, ts *testServer, options ...ClientOptionFunc) *Client {
+	t.Helper()
+	c, err := NewClient(ts.URL, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// successResponse returns a successful load response.
+func successResponse(load testLoad) interface{} {
+	return &BulkResponse{Status: BULK_STATUS_SUCCESS, Label: load.Header.Get(BULK_HEADER_LABEL_KEY)}
+}
+
+// doerFunc is a Doer calling itself.
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// jsonResponse returns a 200 response to req with v encoded as body.
+func jsonResponse(req *http.Request, v interface{}) *http.Response {
+	body, _ := json.Marshal(v)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// fakeClock is a Clock whose time only moves on Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// newFakeClock returns a fakeClock set to now.
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.newTimer(d, d)}
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return c.newTimer(d, 0)
+}
+
+func (c *fakeClock) newTimer(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1), when: c.now.Add(d), period: period, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers and tickers
+// that are due. Like time.Ticker, a ticker drops ticks nobody received.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		for t.active && !t.when.After(c.now) {
+			select {
+			case t.ch <- t.when:
+			default:
+			}
+			if t.period > 0 {
+				t.when = t.when.Add(t.period)
+			} else {
+				t.active = false
+			}
+		}
+	}
+}
+
+// fakeTimer is a Timer of a fakeClock, or a ticker if period is set.
+type fakeTimer struct {
+	c      *fakeClock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.active
+	t.active = true
+	t.when = t.c.now.Add(d)
+	return active
+}
+
+// fakeTicker is a Ticker of a fakeClock.
+type fakeTicker struct{ t *fakeTimer }
+
+func (t fakeTicker) C() <-chan time.Time { return t.t.C() }
+
+func (t fakeTicker) Stop() { t.t.Stop() }
@@ -0,0 +1,101 @@
+package dorisloader
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LabelState is the state of a label in a LabelStore.
+type LabelState int
+
+const (
+	// LabelReserved means a load with the label is in flight. After a
+	// restart, its outcome can be checked with Client.GetLoadState.
+	LabelReserved LabelState = iota
+	// LabelCommitted means the load with the label succeeded.
+	LabelCommitted
+	// LabelFailed means the load with the label failed.
+	LabelFailed
+)
+
+// String returns the name of the state.
+func (s LabelState) String() string {
+	switch s {
+	case LabelReserved:
+		return "reserved"
+	case LabelCommitted:
+		return "committed"
+	case LabelFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// LabelStore remembers the labels of loads, e.g. to load exactly once
+// across restarts. BulkProcessor reserves the label of a batch before
+// committing it and marks it with the outcome afterwards. Implementations
+// can be backed by Redis, a database etc., and must be safe for
+// concurrent use.
+type LabelStore interface {
+	// Reserve records label as in flight. It returns an error if the label
+	// must not be loaded, e.g. because it was committed before.
+	Reserve(label string) error
+	// Mark records the state of label.
+	Mark(label string, state LabelState) error
+}
+
+// MemoryLabelStore is a LabelStore that keeps the labels in memory.
+type MemoryLabelStore struct {
+	mu     sync.Mutex
+	labels map[string]LabelState
+}
+
+// NewMemoryLabelStore returns a new, empty MemoryLabelStore.
+func NewMemoryLabelStore() *MemoryLabelStore {
+	return &MemoryLabelStore{labels: make(map[string]LabelState)}
+}
+
+// Reserve implements LabelStore. It fails if label is reserved or
+// committed; failed labels may be reserved again.
+func (s *MemoryLabelStore) Reserve(label string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.labels[label]; ok && state != LabelFailed {
+		return fmt.Errorf("label %s is already %s", label, state)
+	}
+	s.labels[label] = LabelReserved
+	return nil
+}
+
+// Mark implements LabelStore.
+func (s *MemoryLabelStore) Mark(label string, state LabelState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.labels[label] = state
+	return nil
+}
+
+// State returns the state of label, and false if it is unknown.
+func (s *MemoryLabelStore) State(label string) (LabelState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.labels[label]
+	return state, ok
+}
+
+// Unresolved returns the labels that are still reserved.
+func (s *MemoryLabelStore) Unresolved() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var labels []string
+	for label, state := range s.labels {
+		if state == LabelReserved {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,155 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestMemoryLabelStore(t *testing.T) {
+	s := NewMemoryLabelStore()
+
+	for _, label := range []string{"a", "b", "c"} {
+		if err := s.Reserve(label); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Reserve("a"); err == nil {
+		t.Error("reserving a reserved label succeeded, want an error")
+	}
+
+	if err := s.Mark("a", LabelCommitted); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Mark("b", LabelFailed); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reserve("a"); err == nil {
+		t.Error("reserving a committed label succeeded, want an error")
+	}
+	if state, ok := s.State("a"); !ok || state != LabelCommitted {
+		t.Errorf("state of a = %v, %v, want committed", state, ok)
+	}
+	if _, ok := s.State("unknown"); ok {
+		t.Error("state of an unknown label is known")
+	}
+
+	if got, want := s.Unresolved(), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unresolved = %q, want %q", got, want)
+	}
+
+	// A failed label may be loaded again
+	if err := s.Reserve("b"); err != nil {
+		t.Fatalf("reserving a failed label: %v", err)
+	}
+	got := s.Unresolved()
+	sort.Strings(got)
+	if want := []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unresolved = %q, want %q", got, want)
+	}
+}
+
+func TestBulkProcessorLabelStoreMarks(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 2 {
+			return &BulkResponse{Status: BULK_STATUS_FAIL, Message: "too many filtered rows"}
+		}
+		return successResponse(load)
+	})
+	c := newTestClient(t, ts)
+
+	store := NewMemoryLabelStore()
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil).
+		LabelPrefixFunc(func() string { return "prefix" }).
+		LabelStore(store)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p.Add([]byte("a"))
+	p.Add([]byte("b"))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loads := ts.Loads()
+	if len(loads) != 3 {
+		t.Fatalf("got %d loads, want 3", len(loads))
+	}
+	for i, want := range []LabelState{LabelCommitted, LabelFailed, LabelCommitted} {
+		label := loads[i].Header.Get(BULK_HEADER_LABEL_KEY)
+		if state, ok := store.State(label); !ok || state != want {
+			t.Errorf("state of label %q of load %d = %v, %v, want %v", label, i, state, ok, want)
+		}
+	}
+	if labels := store.Unresolved(); len(labels) != 0 {
+		t.Errorf("unresolved = %q, want none", labels)
+	}
+}
+
+// reserveFailStore is a MemoryLabelStore whose first Reserve fails.
+type reserveFailStore struct {
+	*MemoryLabelStore
+	mu     sync.Mutex
+	failed bool
+}
+
+func (s *reserveFailStore) Reserve(label string) error {
+	s.mu.Lock()
+	first := !s.failed
+	s.failed = true
+	s.mu.Unlock()
+	if first {
+		return errors.New("store unavailable")
+	}
+	return s.MemoryLabelStore.Reserve(label)
+}
+
+func TestBulkProcessorLabelStoreReserveFails(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	store := &reserveFailStore{MemoryLabelStore: NewMemoryLabelStore()}
+	p := NewBulkProcessor(c, "test", "db", "tbl", 1, 1, 0, 0, NewConstantBackoff(0), nil).
+		LabelPrefixFunc(func() string { return "prefix" }).
+		LabelStore(store).
+		EnableEvents(10, true)
+	events := p.Events()
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p.Add([]byte("a"))
+	p.Add([]byte("b"))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rows of the commit that could not be reserved are kept
+	loads := ts.Loads()
+	if len(loads) != 1 || loads[0].Body != "a\nb\n" {
+		t.Fatalf("loads = %+v, want one load of both rows", loads)
+	}
+
+	var errs int
+	for e := range events {
+		if e.Err != nil {
+			errs++
+			if e.Label == "" {
+				t.Error("event of the failed reservation has no label")
+			}
+		}
+	}
+	if errs != 1 {
+		t.Errorf("got %d failed events, want 1", errs)
+	}
+	if stats := p.Stats(); stats.Committed != 2 || stats.Failed != 1 {
+		t.Errorf("stats = %+v, want 2 commits, 1 failed", stats)
+	}
+}
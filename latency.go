@@ -0,0 +1,16 @@
+package dorisloader
+
+import "time"
+
+// LatencyRecorder records the duration of the stream load requests of a
+// client, e.g. in an HdrHistogram or a Prometheus summary to derive
+// latency percentiles. It must be safe for concurrent use. See
+// SetLatencyRecorder.
+type LatencyRecorder interface {
+	Record(db, table string, d time.Duration)
+}
+
+// nopLatencyRecorder is the LatencyRecorder that discards all durations.
+type nopLatencyRecorder struct{}
+
+func (nopLatencyRecorder) Record(db, table string, d time.Duration) {}
@@ -0,0 +1,46 @@
+package dorisloader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLatencyRecorder is a LatencyRecorder remembering the durations.
+type testLatencyRecorder struct {
+	mu        sync.Mutex
+	durations map[string][]time.Duration // db.table -> durations
+}
+
+func (r *testLatencyRecorder) Record(db, table string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.durations[db+"."+table] = append(r.durations[db+"."+table], d)
+}
+
+func TestClientSetLatencyRecorder(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		time.Sleep(delay)
+		return successResponse(load)
+	})
+	recorder := &testLatencyRecorder{durations: map[string][]time.Duration{}}
+	c := newTestClient(t, ts, SetLatencyRecorder(recorder))
+
+	s := NewBulkService(c).DB("db").Table("tbl")
+	s.Add([]byte("a"))
+	if _, err := s.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	durations := recorder.durations["db.tbl"]
+	if len(recorder.durations) != 1 || len(durations) != 1 {
+		t.Fatalf("recorded %v, want a single duration for db.tbl", recorder.durations)
+	}
+	if d := durations[0]; d < delay || d > 5*time.Second {
+		t.Errorf("recorded %v, want at least %v", d, delay)
+	}
+}
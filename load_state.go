@@ -0,0 +1,69 @@
+package dorisloader
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	LOAD_STATE_UNKNOWN   = "UNKNOWN"
+	LOAD_STATE_PREPARE   = "PREPARE"
+	LOAD_STATE_COMMITTED = "COMMITTED"
+	LOAD_STATE_VISIBLE   = "VISIBLE"
+	LOAD_STATE_ABORTED   = "ABORTED"
+)
+
+// GetLoadState returns the state of the load with the given label in db,
+// e.g. LOAD_STATE_VISIBLE.
+func (c *Client) GetLoadState(ctx context.Context, db, label string) (string, error) {
+	opt := PerformRequestOptions{
+		Method: "GET",
+		Path:   "/api/" + db + "/get_load_state",
+		Params: url.Values{"label": []string{label}},
+	}
+	res, err := c.PerformRequest(ctx, opt)
+	if err != nil {
+		return "", err
+	}
+
+	var state string
+	if err := decodeFEResponse(c.decoderFor(&opt), res, &state); err != nil {
+		return "", fmt.Errorf("get load state of %s: %w", label, err)
+	}
+
+	return state, nil
+}
+
+// WaitForVisible polls the state of the load with the given label in db
+// until it is VISIBLE, for at most timeout. It fails early if the load is
+// aborted.
+func (c *Client) WaitForVisible(ctx context.Context, db, label string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		state, err := c.GetLoadState(ctx, db, label)
+		if err == nil && state == LOAD_STATE_VISIBLE {
+			return nil
+		}
+		if err == nil && state == LOAD_STATE_ABORTED {
+			return fmt.Errorf("load %s aborted", label)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("load %s not visible after %v: %w", label, timeout, ctx.Err())
+		case <-time.After(bulkVisiblePollInterval):
+		}
+	}
+}
+
+// WaitForGroupCommit waits until the data of a group commit load, which
+// returns before its data is visible, can be read, e.g. for
+// read-after-write guarantees. label is the one of the BulkResponse; the
+// load state API of Doris looks loads up by label, not by transaction id.
+func (c *Client) WaitForGroupCommit(ctx context.Context, db, label string, timeout time.Duration) error {
+	return c.WaitForVisible(ctx, db, label, timeout)
+}
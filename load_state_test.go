@@ -0,0 +1,52 @@
+package dorisloader
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientWaitForGroupCommit(t *testing.T) {
+	var mu sync.Mutex
+	states := []string{LOAD_STATE_PREPARE, LOAD_STATE_COMMITTED, LOAD_STATE_VISIBLE}
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		state := states[0]
+		if len(states) > 1 {
+			states = states[1:]
+		}
+		return &FEResponse{Msg: "success", Data: []byte(`"` + state + `"`)}
+	})
+	c := newTestClient(t, ts)
+
+	if err := c.WaitForGroupCommit(context.Background(), "db", "group_commit_a1", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	loads := ts.Loads()
+	if len(loads) != 3 {
+		t.Errorf("polled %d times, want 3", len(loads))
+	}
+	for _, load := range loads {
+		if load.Path != "/api/db/get_load_state" {
+			t.Errorf("polled %s, want /api/db/get_load_state", load.Path)
+		}
+	}
+}
+
+func TestClientWaitForGroupCommitAborted(t *testing.T) {
+	ts := newTestServer(t, func(load testLoad) interface{} {
+		return &FEResponse{Msg: "success", Data: []byte(`"ABORTED"`)}
+	})
+	c := newTestClient(t, ts)
+
+	err := c.WaitForGroupCommit(context.Background(), "db", "l", 10*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "aborted") {
+		t.Errorf("err = %v, want an aborted error", err)
+	}
+	if n := len(ts.Loads()); n != 1 {
+		t.Errorf("polled %d times, want 1", n)
+	}
+}
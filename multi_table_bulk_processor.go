@@ -0,0 +1,177 @@
+package dorisloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MultiTableBulkProcessor loads rows destined for several tables. It
+// maintains a BulkProcessor per table, created on the first row for the
+// table, which all share the same settings and flush independently.
+//
+// Each table gets its own workers and flusher, and its processor lives
+// until Close, so it suits a small, known set of tables. Use a BulkProcessor
+// per table instead to set options per table.
+type MultiTableBulkProcessor struct {
+	c                    *Client
+	name                 string
+	numWorkers           int
+	bulkActions          int
+	bulkSize             int
+	flushInterval        time.Duration
+	backoff              Backoff
+	retryItemStatusCodes map[int]struct{}
+
+	mu         sync.Mutex // guards the next block
+	ctx        context.Context
+	started    bool
+	processors map[tableKey]*BulkProcessor
+}
+
+// tableKey identifies a target table.
+type tableKey struct {
+	db    string
+	table string
+}
+
+func NewMultiTableBulkProcessor(
+	client *Client,
+	name string,
+	numWorkers int,
+	bulkActions int,
+	bulkSize int,
+	flushInterval time.Duration,
+	backoff Backoff,
+	retryItemStatusCodes map[int]struct{}) *MultiTableBulkProcessor {
+	return &MultiTableBulkProcessor{
+		c:                    client,
+		name:                 name,
+		numWorkers:           numWorkers,
+		bulkActions:          bulkActions,
+		bulkSize:             bulkSize,
+		flushInterval:        flushInterval,
+		backoff:              backoff,
+		retryItemStatusCodes: retryItemStatusCodes,
+	}
+}
+
+// Start starts the processor. The per-table processors are started with
+// ctx as they are created.
+func (m *MultiTableBulkProcessor) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.started {
+		return nil
+	}
+
+	// Check the settings once, as every table shares them
+	p := m.newProcessor("", "")
+	if err := p.checkInterval(); err != nil {
+		return err
+	}
+
+	m.ctx = ctx
+	m.processors = make(map[tableKey]*BulkProcessor)
+	m.started = true
+
+	return nil
+}
+
+// Stop is an alias for Close.
+func (m *MultiTableBulkProcessor) Stop() error {
+	return m.Close()
+}
+
+// Close stops the processors of all tables, committing outstanding rows.
+func (m *MultiTableBulkProcessor) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Already stopped? Do nothing.
+	if !m.started {
+		return nil
+	}
+
+	var firstErr error
+	for _, p := range m.processors {
+		if err := p.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	m.started = false
+
+	return firstErr
+}
+
+// AddTo adds a single row to commit to the given db and table. It returns
+// ErrBulkProcessorClosed if the processor is not running.
+func (m *MultiTableBulkProcessor) AddTo(db, table string, row []byte) error {
+	p, err := m.processor(db, table)
+	if err != nil {
+		return err
+	}
+	return p.TryAdd(row)
+}
+
+// Flush manually asks the processors of all tables to commit their
+// outstanding rows. It returns the first error encountered.
+func (m *MultiTableBulkProcessor) Flush() error {
+	var firstErr error
+	for _, p := range m.snapshot() {
+		if err := p.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats returns the statistics per table, keyed by "db.table".
+func (m *MultiTableBulkProcessor) Stats() map[string]BulkProcessorStats {
+	stats := make(map[string]BulkProcessorStats)
+	for key, p := range m.snapshot() {
+		stats[key.db+"."+key.table] = p.Stats()
+	}
+	return stats
+}
+
+// processor returns the started processor for db and table, creating it
+// if necessary.
+func (m *MultiTableBulkProcessor) processor(db, table string) (*BulkProcessor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started {
+		return nil, ErrBulkProcessorClosed
+	}
+
+	key := tableKey{db: db, table: table}
+	if p, ok := m.processors[key]; ok {
+		return p, nil
+	}
+	p := m.newProcessor(db, table)
+	if err := p.Start(m.ctx); err != nil {
+		return nil, err
+	}
+	m.processors[key] = p
+	return p, nil
+}
+
+// snapshot returns a copy of the processors per table.
+func (m *MultiTableBulkProcessor) snapshot() map[tableKey]*BulkProcessor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	processors := make(map[tableKey]*BulkProcessor, len(m.processors))
+	for key, p := range m.processors {
+		processors[key] = p
+	}
+	return processors
+}
+
+// newProcessor creates a processor for db and table with the shared settings.
+func (m *MultiTableBulkProcessor) newProcessor(db, table string) *BulkProcessor {
+	return NewBulkProcessor(m.c, m.name, db, table, m.numWorkers, m.bulkActions,
+		m.bulkSize, m.flushInterval, m.backoff, m.retryItemStatusCodes)
+}
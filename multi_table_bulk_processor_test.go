@@ -0,0 +1,124 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestMultiTableBulkProcessorRoutesPerTable(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	m := NewMultiTableBulkProcessor(c, "test", 1, 100, 0, 0, NewConstantBackoff(0), nil)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for _, r := range []struct{ db, table, row string }{
+		{"db", "a", "1"},
+		{"db", "b", "2"},
+		{"db", "a", "3"},
+		{"other", "a", "4"},
+	} {
+		if err := m.AddTo(r.db, r.table, []byte(r.row)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, load := range ts.Loads() {
+		got = append(got, load.Path+" "+load.Body)
+	}
+	sort.Strings(got)
+	want := []string{
+		"/api/db/a/_stream_load 1\n3\n",
+		"/api/db/b/_stream_load 2\n",
+		"/api/other/a/_stream_load 4\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("loads = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("load %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	stats := m.Stats()
+	if len(stats) != 3 {
+		t.Fatalf("got stats for %d tables, want 3", len(stats))
+	}
+	for _, key := range []string{"db.a", "db.b", "other.a"} {
+		if stats[key].Succeeded != 1 {
+			t.Errorf("stats[%q].Succeeded = %d, want 1", key, stats[key].Succeeded)
+		}
+	}
+}
+
+func TestMultiTableBulkProcessorCloseCommits(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	m := NewMultiTableBulkProcessor(c, "test", 1, 100, 0, 0, NewConstantBackoff(0), nil)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddTo("db", "a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddTo("db", "b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ts.Loads()); n != 2 {
+		t.Errorf("got %d loads after Close, want 2", n)
+	}
+
+	if err := m.AddTo("db", "a", []byte("3")); !errors.Is(err, ErrBulkProcessorClosed) {
+		t.Errorf("AddTo after Close = %v, want ErrBulkProcessorClosed", err)
+	}
+}
+
+func TestMultiTableBulkProcessorAddToWhileClosing(t *testing.T) {
+	ts := newTestServer(t, successResponse)
+	c := newTestClient(t, ts)
+
+	m := NewMultiTableBulkProcessor(c, "test", 1, 10, 0, 0, NewConstantBackoff(0), nil)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddTo("db", "a", []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				err := m.AddTo("db", "a", []byte("1"))
+				if errors.Is(err, ErrBulkProcessorClosed) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
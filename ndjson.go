@@ -0,0 +1,115 @@
+package dorisloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// NDJSON marshals every element of the slice or array items to JSON,
+// each on its own line, e.g. for a json load with read_json_by_line.
+func NDJSON(items interface{}) ([]byte, error) {
+	rows, err := marshalRows(items)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, row := range rows {
+		buf.Write(row)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// AddAll marshals every element of the slice or array items to JSON and
+// adds it as a row. Nothing is added if an element can't be marshaled.
+func (s *BulkService) AddAll(items interface{}) error {
+	rows, err := marshalRows(items)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := s.checkRowSize(row); err != nil {
+			return err
+		}
+	}
+	// The rows are ours, so don't copy them again
+	s.rows = append(s.rows, rows...)
+	return nil
+}
+
+// AddJSON marshals every value to JSON and adds it as a row. Values that
+// can't be marshaled are skipped and the first such error is returned.
+// Unless a format is set, it sets the format to BULK_FORMAT_JSON and,
+// unless StripOuterArray is set, enables read_json_by_line.
+func (s *BulkService) AddJSON(vals ...interface{}) error {
+	if s.format == "" {
+		s.Format(BULK_FORMAT_JSON)
+		if !s.stripOuterArray && s.headers.Get(BULK_HEADER_READ_JSON_BY_LINE_KEY) == "" {
+			s.setHeader(BULK_HEADER_READ_JSON_BY_LINE_KEY, "true")
+		}
+	}
+	var firstErr error
+	for i, val := range vals {
+		row, err := marshalRow(i, val)
+		if err == nil {
+			err = s.checkRowSize(row)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		// The row is ours, so don't copy it again
+		s.rows = append(s.rows, row)
+	}
+	return firstErr
+}
+
+// marshalRows marshals every element of the slice or array items to JSON.
+func marshalRows(items interface{}) ([][]byte, error) {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("items must be a slice or array, got %T", items)
+	}
+	rows := make([][]byte, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		row, err := marshalRow(i, v.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// AddTyped marshals every item to JSON and adds it as a row, like
+// AddAll but without reflecting over the slice. Nothing is added if an
+// item can't be marshaled.
+func AddTyped[T any](s *BulkService, items []T) error {
+	rows := make([][]byte, 0, len(items))
+	for i, item := range items {
+		row, err := marshalRow(i, item)
+		if err != nil {
+			return err
+		}
+		if err := s.checkRowSize(row); err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	// The rows are ours, so don't copy them again
+	s.rows = append(s.rows, rows...)
+	return nil
+}
+
+// marshalRow marshals the i-th item to JSON.
+func marshalRow(i int, item interface{}) ([]byte, error) {
+	row, err := json.Marshal(item)
+	if err != nil {
+		return nil, fmt.Errorf("item %d: %v", i, err)
+	}
+	return row, nil
+}
@@ -0,0 +1,102 @@
+package dorisloader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNDJSON(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	items := []item{{1, "a"}, {2, "b"}, {3, "c"}}
+
+	data, err := NDJSON(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1,"name":"a"}` + "\n" + `{"id":2,"name":"b"}` + "\n" + `{"id":3,"name":"c"}` + "\n"
+	if string(data) != want {
+		t.Errorf("NDJSON = %q, want %q", data, want)
+	}
+
+	s := NewBulkService(nil)
+	if err := s.AddAll(items); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.NumberOfRows(); n != 3 {
+		t.Fatalf("added %d rows, want 3", n)
+	}
+	for i, line := range strings.Split(strings.TrimSuffix(want, "\n"), "\n") {
+		if got := string(s.rows[i]); got != line {
+			t.Errorf("row %d = %s, want %s", i, got, line)
+		}
+	}
+
+	if _, err := NDJSON(items[0]); err == nil {
+		t.Error("NDJSON accepted a struct instead of a slice")
+	}
+}
+
+func TestAddTyped(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	s := NewBulkService(nil)
+	if err := AddTyped(s, []item{{1, "a"}, {2, "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"id":1,"name":"a"}`, `{"id":2,"name":"b"}`}
+	if n := s.NumberOfRows(); n != len(want) {
+		t.Fatalf("added %d rows, want %d", n, len(want))
+	}
+	for i, row := range want {
+		if got := string(s.rows[i]); got != row {
+			t.Errorf("row %d = %s, want %s", i, got, row)
+		}
+	}
+
+	// Nothing is added if an item can't be marshaled
+	if err := AddTyped(s, []interface{}{3, make(chan int)}); err == nil {
+		t.Error("AddTyped accepted an item that can't be marshaled")
+	}
+	if n := s.NumberOfRows(); n != len(want) {
+		t.Errorf("got %d rows after a failed AddTyped, want %d", n, len(want))
+	}
+}
+
+func TestBulkServiceAddJSON(t *testing.T) {
+	type item struct {
+		ID int `json:"id"`
+	}
+
+	s := NewBulkService(nil)
+	err := s.AddJSON(item{1}, map[string]interface{}{"id": 2, "name": "b"}, make(chan int), &item{3})
+	if err == nil || !strings.Contains(err.Error(), "item 2") {
+		t.Errorf("err = %v, want an error for item 2", err)
+	}
+	want := []string{`{"id":1}`, `{"id":2,"name":"b"}`, `{"id":3}`}
+	if n := s.NumberOfRows(); n != len(want) {
+		t.Fatalf("added %d rows, want %d", n, len(want))
+	}
+	for i, row := range want {
+		if got := string(s.rows[i]); got != row {
+			t.Errorf("row %d = %s, want %s", i, got, row)
+		}
+	}
+	if s.format != BULK_FORMAT_JSON || s.headers.Get(BULK_HEADER_READ_JSON_BY_LINE_KEY) != "true" {
+		t.Errorf("format = %q, read_json_by_line = %q, want json by line", s.format, s.headers.Get(BULK_HEADER_READ_JSON_BY_LINE_KEY))
+	}
+
+	// A configured format is kept
+	s = NewBulkService(nil).Format(BULK_FORMAT_JSON).StripOuterArray(true)
+	if err := s.AddJSON(item{1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.headers.Get(BULK_HEADER_READ_JSON_BY_LINE_KEY); got != "" {
+		t.Errorf("read_json_by_line = %q with StripOuterArray, want unset", got)
+	}
+}
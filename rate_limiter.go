@@ -0,0 +1,62 @@
+package dorisloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that paces the bytes sent to rate bytes
+// per second, allowing bursts of up to one second worth of bytes.
+type rateLimiter struct {
+	rate float64 // bytes per second
+
+	mu     sync.Mutex // guards the next block
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSec), tokens: float64(bytesPerSec), last: time.Now()}
+}
+
+// wait blocks until n bytes may be sent, or ctx is done. Requests larger
+// than the bucket are let through once it is full and delay the next ones
+// accordingly.
+func (l *rateLimiter) wait(ctx context.Context, n int64) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	// Reserve the tokens now so that waiters are served in order
+	need := float64(n)
+	if need > l.rate {
+		need = l.rate
+	}
+	var delay time.Duration
+	if l.tokens < need {
+		delay = time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+	}
+	l.tokens -= float64(n)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// Give the tokens back
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 type Request http.Request
@@ -23,10 +24,79 @@ func NewRequest(method, url string, body io.Reader) (*Request, error) {
 		return nil, err
 	}
 
-	req.Header.Add("User-Agent", "DorisLoader/"+Version+" ("+runtime.GOOS+"-"+runtime.GOARCH+")")
+	req.Header.Add("User-Agent", defaultUserAgent())
+
+	// http.NewRequest only knows the length of a few reader types
+	if req.ContentLength == 0 && req.Body != nil && req.Body != http.NoBody {
+		if n, ok := bodyLength(body); ok {
+			req.ContentLength = n
+		}
+	}
+
+	// Bodies that can be rewound can be sent again, e.g. to follow the
+	// redirect of a Doris FE to a BE. Other bodies can't, as net/http only
+	// knows how to rewind a few reader types.
+	if req.GetBody == nil && req.Body != nil && req.Body != http.NoBody {
+		if gz, ok := body.(*gzipStream); ok {
+			// Compress the source again from its start
+			if s, ok := gz.src.(io.Seeker); ok {
+				if start, err := s.Seek(0, io.SeekCurrent); err == nil {
+					cur := gz
+					req.GetBody = func() (io.ReadCloser, error) {
+						cur.Close()
+						if _, err := s.Seek(start, io.SeekStart); err != nil {
+							return nil, err
+						}
+						cur = newGzipStream(gz.src)
+						return cur, nil
+					}
+				}
+			}
+		} else if s, ok := body.(io.Seeker); ok {
+			if start, err := s.Seek(0, io.SeekCurrent); err == nil {
+				// Don't let the transport close the caller's reader
+				req.Body = io.NopCloser(body)
+				req.GetBody = func() (io.ReadCloser, error) {
+					if _, err := s.Seek(start, io.SeekStart); err != nil {
+						return nil, err
+					}
+					return io.NopCloser(body), nil
+				}
+			}
+		}
+	}
+
 	return (*Request)(req), nil
 }
 
+// bodyLength returns the number of bytes remaining in body, if that can
+// be determined without reading it.
+func bodyLength(body io.Reader) (int64, bool) {
+	switch b := body.(type) {
+	case interface{ Len() int }:
+		return int64(b.Len()), true
+	case io.Seeker:
+		cur, err := b.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := b.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := b.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+// defaultUserAgent returns the User-Agent sent by default.
+func defaultUserAgent() string {
+	return "DorisLoader/" + Version + " (" + runtime.GOOS + "-" + runtime.GOARCH + ")"
+}
+
 func (r *Request) SetBasicAuth(username, password string) {
 	((*http.Request)(r)).SetBasicAuth(username, password)
 }
@@ -38,6 +108,11 @@ func handleGetBodyReader(header http.Header, body interface{}, gzipCompress bool
 			return getBodyGzipReader(header, b)
 		}
 		return getBodyString(b)
+	case io.Reader:
+		if gzipCompress {
+			return getBodyGzipReader(header, b)
+		}
+		return b, nil
 	default:
 		if gzipCompress {
 			return getBodyGzipReader(header, body)
@@ -73,6 +148,11 @@ func getBodyGzipReader(header http.Header, body interface{}) (io.Reader, error)
 		header.Add("Content-Encoding", "gzip")
 		header.Add("Vary", "Accept-Encoding")
 		return bytes.NewReader(buf.Bytes()), nil
+	case io.Reader:
+		// Compress while sending rather than buffering the whole stream
+		header.Add("Content-Encoding", "gzip")
+		header.Add("Vary", "Accept-Encoding")
+		return newGzipStream(b), nil
 	default:
 		data, err := json.Marshal(b)
 		if err != nil {
@@ -92,3 +172,45 @@ func getBodyGzipReader(header http.Header, body interface{}) (io.Reader, error)
 		return bytes.NewReader(buf.Bytes()), nil
 	}
 }
+
+// gzipStream compresses src while it is read, so that a large stream is
+// never held in memory. Its length is unknown, so it is sent chunked.
+type gzipStream struct {
+	src   io.Reader
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+	start sync.Once
+	done  chan struct{} // closed when the compressing goroutine returns
+}
+
+func newGzipStream(src io.Reader) *gzipStream {
+	pr, pw := io.Pipe()
+	return &gzipStream{src: src, pr: pr, pw: pw, done: make(chan struct{})}
+}
+
+func (s *gzipStream) Read(p []byte) (int, error) {
+	// Start compressing on the first read only, so that a request that
+	// is never sent doesn't leave a goroutine behind
+	s.start.Do(func() {
+		go func() {
+			defer close(s.done)
+			w := gzip.NewWriter(s.pw)
+			_, err := io.Copy(w, s.src)
+			if err == nil {
+				err = w.Close()
+			}
+			s.pw.CloseWithError(err)
+		}()
+	})
+	return s.pr.Read(p)
+}
+
+// Close stops compressing and waits until src is no longer read. It
+// doesn't close src.
+func (s *gzipStream) Close() error {
+	s.pr.Close()
+	// If compressing never started, nothing reads src
+	s.start.Do(func() { close(s.done) })
+	<-s.done
+	return nil
+}
@@ -0,0 +1,64 @@
+package dorisloader
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewRequestContentLength(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		body interface{}
+		gzip bool
+	}{
+		{name: "string", body: "a\nb\n"},
+		{name: "json", body: map[string]int{"a": 1}},
+		{name: "gzip string", body: "a\nb\n", gzip: true},
+		{name: "gzip json", body: map[string]int{"a": 1}, gzip: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := handleGetBodyReader(http.Header{}, tt.body, tt.gzip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := NewRequest("PUT", "http://fehost:8030/api/db/tbl/_stream_load", r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.ContentLength != int64(len(body)) || req.ContentLength == 0 {
+				t.Errorf("ContentLength = %d, want %d", req.ContentLength, len(body))
+			}
+		})
+	}
+}
+
+func TestNewRequestContentLengthSeeker(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rows")
+	if err := ioutil.WriteFile(name, []byte("skip\na\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := NewRequest("PUT", "http://fehost:8030/api/db/tbl/_stream_load", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ContentLength != 4 {
+		t.Errorf("ContentLength = %d, want the remaining 4 bytes", req.ContentLength)
+	}
+}
@@ -3,6 +3,8 @@ package dorisloader
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // Response represents a response from Elasticsearch.
@@ -16,4 +18,105 @@ type Response struct {
 	Body json.RawMessage
 	// DeprecationWarnings lists all deprecation warnings returned from
 	DeprecationWarnings []string
+	// BackendHost is the host of the final request, e.g. the BE that
+	// handled the load after a redirect from the FE.
+	BackendHost string
+	// SentBytes is the size of the request body as put on the wire,
+	// i.e. after compression.
+	SentBytes int64
+}
+
+// DeprecationWarning is a warning of a Warning header as of RFC 7234,
+// e.g. `299 doris "option x is deprecated"`.
+type DeprecationWarning struct {
+	Code  int    // warn-code, e.g. 299, or 0 if the warning is malformed
+	Agent string // warn-agent, e.g. the host that added the warning
+	Text  string // warn-text without quotes, or the raw warning if malformed
+}
+
+// ParsedWarnings parses the DeprecationWarnings. A header value may
+// hold several comma-separated warnings. Warnings that can't be parsed
+// are returned with their raw text.
+func (r *Response) ParsedWarnings() []DeprecationWarning {
+	var warnings []DeprecationWarning
+	for _, v := range r.DeprecationWarnings {
+		for v = strings.TrimSpace(v); v != ""; v = strings.TrimSpace(v) {
+			w, rest, ok := parseWarning(v)
+			if !ok {
+				warnings = append(warnings, DeprecationWarning{Text: v})
+				break
+			}
+			warnings = append(warnings, w)
+			v = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+		}
+	}
+	return warnings
+}
+
+// parseWarning parses the first warning of v,
+// i.e. warn-code SP warn-agent SP warn-text [SP warn-date],
+// and returns the remainder of v.
+func parseWarning(v string) (DeprecationWarning, string, bool) {
+	var w DeprecationWarning
+
+	i := strings.IndexByte(v, ' ')
+	if i != 3 {
+		return w, "", false
+	}
+	code, err := strconv.Atoi(v[:i])
+	if err != nil || code < 100 {
+		return w, "", false
+	}
+	w.Code = code
+	v = v[i+1:]
+
+	i = strings.IndexByte(v, ' ')
+	if i <= 0 {
+		return w, "", false
+	}
+	w.Agent = v[:i]
+	v = v[i+1:]
+
+	text, rest, ok := parseQuotedString(v)
+	if !ok {
+		return w, "", false
+	}
+	w.Text = text
+
+	// Skip the optional warn-date
+	rest = strings.TrimLeft(rest, " ")
+	if strings.HasPrefix(rest, `"`) {
+		_, rest, ok = parseQuotedString(rest)
+		if !ok {
+			return w, "", false
+		}
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != ',' {
+		return w, "", false
+	}
+	return w, rest, true
+}
+
+// parseQuotedString parses the quoted-string at the start of v and
+// returns its unescaped content and the remainder of v.
+func parseQuotedString(v string) (string, string, bool) {
+	if !strings.HasPrefix(v, `"`) {
+		return "", "", false
+	}
+	var b strings.Builder
+	for i := 1; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '"':
+			return b.String(), v[i+1:], true
+		case '\\':
+			if i+1 == len(v) {
+				return "", "", false
+			}
+			i++
+			b.WriteByte(v[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false
 }
@@ -0,0 +1,43 @@
+package dorisloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResponseParsedWarnings(t *testing.T) {
+	for _, tt := range []struct {
+		header []string
+		want   []DeprecationWarning
+	}{
+		{
+			header: []string{`299 doris "option x is deprecated"`},
+			want:   []DeprecationWarning{{Code: 299, Agent: "doris", Text: "option x is deprecated"}},
+		},
+		{
+			header: []string{`199 proxy:8080 "say \"hi\"" "Sat, 01 Jun 2024 12:00:00 GMT", 299 - "second"`},
+			want: []DeprecationWarning{
+				{Code: 199, Agent: "proxy:8080", Text: `say "hi"`},
+				{Code: 299, Agent: "-", Text: "second"},
+			},
+		},
+		{
+			header: []string{"deprecated option", `299 doris "unterminated`, `99 doris "short code"`},
+			want: []DeprecationWarning{
+				{Text: "deprecated option"},
+				{Text: `299 doris "unterminated`},
+				{Text: `99 doris "short code"`},
+			},
+		},
+		{
+			header: []string{`299 doris "ok", garbage`},
+			want:   []DeprecationWarning{{Code: 299, Agent: "doris", Text: "ok"}, {Text: "garbage"}},
+		},
+		{header: nil, want: nil},
+	} {
+		r := &Response{DeprecationWarnings: tt.header}
+		if got := r.ParsedWarnings(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsedWarnings(%q) = %+v, want %+v", tt.header, got, tt.want)
+		}
+	}
+}
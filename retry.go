@@ -1,6 +1,14 @@
 package dorisloader
 
-import "time"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // An Operation is executing by Retry() or RetryNotify().
 // The operation will be retried using a backoff policy if it returns an error.
@@ -13,6 +21,43 @@ type Operation func() error
 // the notify function isn't called.
 type Notify func(error)
 
+// NotifyWait is like Notify, but also receives the time to wait before
+// the next attempt.
+type NotifyWait func(error, time.Duration)
+
+// MaxRetryAfter caps the server's Retry-After for backoffs that have no
+// maximum interval of their own.
+var MaxRetryAfter = time.Minute
+
+// maxRetryAfterWait returns the longest Retry-After RetryNotify waits for
+// when retrying with b.
+func maxRetryAfterWait(b Backoff) time.Duration {
+	if mb, ok := b.(interface{ MaxInterval() time.Duration }); ok {
+		return mb.MaxInterval()
+	}
+	return MaxRetryAfter
+}
+
+// PermanentError wraps an error that must not be retried. RetryNotify
+// returns the wrapped error right away.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err so that it is not retried.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
 // Retry the function f until it does not return error or BackOff stops.
 // f is guaranteed to be run at least once.
 // It is the caller's responsibility to reset b after Retry returns.
@@ -21,9 +66,20 @@ type Notify func(error)
 // failed operation returns.
 func Retry(o Operation, b Backoff) error { return RetryNotify(o, b, nil) }
 
-// RetryNotify calls notify function with the error and wait duration
+// RetryNotify calls notify function with the error
 // for each failed attempt before sleep.
 func RetryNotify(operation Operation, b Backoff, notify Notify) error {
+	var notifyWait NotifyWait
+	if notify != nil {
+		notifyWait = func(err error, _ time.Duration) { notify(err) }
+	}
+	return RetryNotifyWithContext(context.Background(), operation, b, notifyWait)
+}
+
+// RetryNotifyWithContext is like RetryNotify, but stops retrying as soon
+// as ctx is done, returning the error of ctx, and calls notify with the
+// time to wait as well.
+func RetryNotifyWithContext(ctx context.Context, operation Operation, b Backoff, notify NotifyWait) error {
 	var err error
 	var wait time.Duration
 	var retry bool
@@ -34,16 +90,91 @@ func RetryNotify(operation Operation, b Backoff, notify Notify) error {
 			return nil
 		}
 
+		var perr *PermanentError
+		if errors.As(err, &perr) {
+			return perr.Err
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
 		n++
 		wait, retry = b.Next(n)
 		if !retry {
 			return err
 		}
 
+		// Honor the server's Retry-After, if any
+		var rae *RetryAfterError
+		if errors.As(err, &rae) && rae.RetryAfter > 0 {
+			wait = rae.RetryAfter
+			if max := maxRetryAfterWait(b); wait > max {
+				wait = max
+			}
+		}
+
 		if notify != nil {
-			notify(err)
+			notify(err, wait)
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
 		}
+	}
+}
 
-		time.Sleep(wait)
+// RetryAfterError is returned by PerformRequest if the server responds
+// with 429 Too Many Requests or 503 Service Unavailable. RetryNotify
+// waits for RetryAfter, if set, instead of the backoff's interval, but no
+// longer than the backoff's maximum interval (see ExponentialBackoff.MaxInterval)
+// or MaxRetryAfter for backoffs without one.
+type RetryAfterError struct {
+	StatusCode int
+	RetryAfter time.Duration // zero if no valid Retry-After header was sent
+	Err        *HTTPError    // the underlying HTTP error
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("http status %d, retry after %v", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("http status %d", e.StatusCode)
+}
+
+// Unwrap returns the underlying HTTP error.
+func (e *RetryAfterError) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
+
+// ParseRetryAfter returns the duration of the Retry-After header in h,
+// given either in seconds or as an HTTP date. It returns false if the
+// header is missing or invalid.
+func ParseRetryAfter(h http.Header) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := time.Until(t)
+	if d < 0 {
+		d = 0
 	}
+	return d, true
 }
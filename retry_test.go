@@ -0,0 +1,115 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryNotify(t *testing.T) {
+	errFail := errors.New("fail")
+	var calls int
+	op := func() error {
+		calls++
+		if calls < 3 {
+			return errFail
+		}
+		return nil
+	}
+
+	var notified []error
+	var notify Notify = func(err error) { notified = append(notified, err) }
+	if err := RetryNotify(op, NewConstantBackoff(time.Millisecond), notify); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(notified) != 2 {
+		t.Errorf("calls = %d, notified = %d, want 3 and 2", calls, len(notified))
+	}
+
+	calls = 0
+	var waits []time.Duration
+	notifyWait := func(err error, wait time.Duration) { waits = append(waits, wait) }
+	if err := RetryNotifyWithContext(context.Background(), op, NewConstantBackoff(time.Millisecond), notifyWait); err != nil {
+		t.Fatal(err)
+	}
+	if len(waits) != 2 || waits[0] != time.Millisecond {
+		t.Errorf("waits = %v, want 2 of 1ms", waits)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	for _, tt := range []struct {
+		value  string
+		min    time.Duration
+		max    time.Duration
+		wantOK bool
+	}{
+		{"120", 120 * time.Second, 120 * time.Second, true},
+		{date, 59 * time.Minute, time.Hour, true},
+		{"", 0, 0, false},
+		{"-1", 0, 0, false},
+		{"soon", 0, 0, false},
+	} {
+		d, ok := ParseRetryAfter(http.Header{"Retry-After": {tt.value}})
+		if ok != tt.wantOK || d < tt.min || d > tt.max {
+			t.Errorf("ParseRetryAfter(%q) = %v, %v, want [%v, %v], %v", tt.value, d, ok, tt.min, tt.max, tt.wantOK)
+		}
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	var calls int
+	op := func() error {
+		calls++
+		if calls == 1 {
+			return &RetryAfterError{StatusCode: http.StatusTooManyRequests, RetryAfter: 5 * time.Millisecond}
+		}
+		return nil
+	}
+	var waits []time.Duration
+	notify := func(err error, wait time.Duration) { waits = append(waits, wait) }
+	if err := RetryNotifyWithContext(context.Background(), op, NewConstantBackoff(time.Hour), notify); err != nil {
+		t.Fatal(err)
+	}
+	if len(waits) != 1 || waits[0] != 5*time.Millisecond {
+		t.Errorf("waits = %v, want the Retry-After of 5ms", waits)
+	}
+}
+
+func TestRetryClampsRetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		b    Backoff
+		want time.Duration
+	}{
+		{"exponential", NewExponentialBackoff(time.Millisecond, 10*time.Millisecond), 10 * time.Millisecond},
+		{"constant", NewConstantBackoff(time.Millisecond), MaxRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			op := func() error {
+				calls++
+				if calls == 1 {
+					return &RetryAfterError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 24 * time.Hour}
+				}
+				return nil
+			}
+			var waits []time.Duration
+			notify := func(err error, wait time.Duration) { waits = append(waits, wait) }
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.want == MaxRetryAfter {
+				// Don't actually wait a minute
+				notify = func(err error, wait time.Duration) { waits = append(waits, wait); cancel() }
+			}
+			_ = RetryNotifyWithContext(ctx, op, tt.b, notify)
+			if len(waits) != 1 || waits[0] != tt.want {
+				t.Errorf("waits = %v, want %v", waits, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// ErrTransactionNotActive is returned by Transaction if it has not begun
+// or has already been committed or aborted.
+var ErrTransactionNotActive = errors.New("transaction is not active")
+
+// Transaction loads many batches into a table atomically, using the
+// transaction API of Doris: Begin starts a transaction under a label,
+// Load appends batches to it, and Commit makes all of them visible at
+// once, while Abort discards them. It is not safe for concurrent use.
+type Transaction struct {
+	c       *Client
+	table   string
+	service *BulkService // template for the load options
+
+	db     string
+	label  string
+	txnID  int64
+	active bool
+}
+
+// NewTransaction creates a new transaction loading into table.
+func NewTransaction(c *Client, table string) *Transaction {
+	return &Transaction{c: c, table: table, service: NewBulkService(c).Table(table)}
+}
+
+// Service returns the BulkService whose load options, e.g. Format or
+// Columns, are used for every Load. Its rows, DB and label are ignored.
+func (t *Transaction) Service() *BulkService {
+	return t.service
+}
+
+// TxnID returns the id of the transaction, or 0 if it has not begun.
+func (t *Transaction) TxnID() int64 {
+	return t.txnID
+}
+
+// Label returns the label of the transaction.
+func (t *Transaction) Label() string {
+	return t.label
+}
+
+// Begin starts a transaction in db under label.
+func (t *Transaction) Begin(ctx context.Context, db, label string) error {
+	if t.active {
+		return errors.New("transaction has already begun")
+	}
+	t.db = db
+	t.label = label
+	res, err := t.do(ctx, "/api/"+db+"/_begin")
+	if err != nil {
+		return err
+	}
+	t.txnID = res.TxnID
+	t.active = true
+	return nil
+}
+
+// Load appends rows to the transaction. They become visible on Commit.
+func (t *Transaction) Load(ctx context.Context, rows [][]byte) (*BulkResponse, error) {
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+
+	s := getBulkService(t.c)
+	defer putBulkService(s)
+	buf := s.rows
+	*s = *t.service
+	s.rows = buf
+	s.sizeInBytes = 0
+	s.sizeInBytesCursor = 0
+	s.db = t.db
+	s.headers = t.service.headers.Clone()
+	s.Label(t.label)
+	s.setHeader(BULK_HEADER_TXN_ID_KEY, strconv.FormatInt(t.txnID, 10))
+	s.Add(rows...)
+
+	opt, err := s.BuildRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opt.Path = "/api/" + t.db + "/" + t.table + "/_load"
+	return s.perform(ctx, opt)
+}
+
+// Commit commits the transaction, making all loaded rows visible.
+func (t *Transaction) Commit(ctx context.Context) error {
+	return t.finish(ctx, "_commit")
+}
+
+// Abort rolls the transaction back, discarding all loaded rows.
+func (t *Transaction) Abort(ctx context.Context) error {
+	return t.finish(ctx, "_rollback")
+}
+
+// finish ends the transaction with the given operation. If the request
+// fails, the transaction stays active, so that it can be retried or
+// aborted.
+func (t *Transaction) finish(ctx context.Context, op string) error {
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	if _, err := t.do(ctx, "/api/"+t.db+"/"+op); err != nil {
+		return err
+	}
+	t.active = false
+	return nil
+}
+
+// do performs a transaction control request and checks its status.
+func (t *Transaction) do(ctx context.Context, path string) (*BulkResponse, error) {
+	headers := http.Header{}
+	headers.Set(t.service.labelKey(), t.label)
+	if t.txnID != 0 {
+		headers.Set(BULK_HEADER_TXN_ID_KEY, strconv.FormatInt(t.txnID, 10))
+	}
+	opt := PerformRequestOptions{
+		Method:  "POST",
+		Path:    path,
+		Headers: headers,
+		Decoder: t.service.decoder,
+	}
+	res, err := t.c.PerformRequest(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := new(BulkResponse)
+	if err := t.c.decoderFor(&opt).Decode(res.Body, ret); err != nil {
+		return nil, err
+	}
+	if !t.service.isSuccessStatus(ret.Status) {
+		return nil, &BulkStatusError{Response: ret}
+	}
+	return ret, nil
+}
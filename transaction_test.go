@@ -0,0 +1,100 @@
+package dorisloader
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransactionFinishRetry(t *testing.T) {
+	for _, op := range []string{"_commit", "_rollback"} {
+		failed := false
+		ts := newTestServer(t, func(load testLoad) interface{} {
+			if strings.HasSuffix(load.Path, op) && !failed {
+				failed = true
+				return &BulkResponse{Status: BULK_STATUS_FAIL, Message: "unavailable"}
+			}
+			return &BulkResponse{Status: BULK_STATUS_SUCCESS, TxnID: 42}
+		})
+		txn := NewTransaction(newTestClient(t, ts), "tbl")
+		finish := txn.Commit
+		if op == "_rollback" {
+			finish = txn.Abort
+		}
+
+		ctx := context.Background()
+		if err := txn.Begin(ctx, "db", "l"); err != nil {
+			t.Fatal(err)
+		}
+		if err := finish(ctx); err == nil {
+			t.Fatalf("%s: first attempt succeeded, want error", op)
+		}
+		if err := finish(ctx); err != nil {
+			t.Fatalf("%s: retry: %v", op, err)
+		}
+		if err := finish(ctx); !errors.Is(err, ErrTransactionNotActive) {
+			t.Errorf("%s: after success: err = %v, want ErrTransactionNotActive", op, err)
+		}
+
+		loads := ts.Loads()
+		if len(loads) != 3 || loads[2].Header.Get(BULK_HEADER_TXN_ID_KEY) != "42" {
+			t.Errorf("%s: loads = %+v, want begin and two attempts with txn id 42", op, loads)
+		}
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	for _, op := range []string{"_commit", "_rollback"} {
+		ts := newTestServer(t, func(load testLoad) interface{} {
+			return &BulkResponse{Status: BULK_STATUS_SUCCESS, TxnID: 42}
+		})
+		txn := NewTransaction(newTestClient(t, ts), "tbl")
+
+		ctx := context.Background()
+		if _, err := txn.Load(ctx, [][]byte{[]byte("a")}); !errors.Is(err, ErrTransactionNotActive) {
+			t.Errorf("%s: load before begin: err = %v, want ErrTransactionNotActive", op, err)
+		}
+		if err := txn.Begin(ctx, "db", "l"); err != nil {
+			t.Fatal(err)
+		}
+		if txn.TxnID() != 42 || txn.Label() != "l" {
+			t.Errorf("%s: txn id = %d, label = %q, want 42 and l", op, txn.TxnID(), txn.Label())
+		}
+		for _, rows := range [][][]byte{{[]byte("a"), []byte("b")}, {[]byte("c")}} {
+			if _, err := txn.Load(ctx, rows); err != nil {
+				t.Fatal(err)
+			}
+		}
+		finish := txn.Commit
+		if op == "_rollback" {
+			finish = txn.Abort
+		}
+		if err := finish(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []struct{ method, path, body string }{
+			{"POST", "/api/db/_begin", ""},
+			{"PUT", "/api/db/tbl/_load", "a\nb\n"},
+			{"PUT", "/api/db/tbl/_load", "c\n"},
+			{"POST", "/api/db/" + op, ""},
+		}
+		loads := ts.Loads()
+		if len(loads) != len(want) {
+			t.Fatalf("%s: got %d requests, want %d", op, len(loads), len(want))
+		}
+		for i, w := range want {
+			l := loads[i]
+			if l.Method != w.method || l.Path != w.path || (w.body != "" && l.Body != w.body) {
+				t.Errorf("%s: request %d = %s %s %q, want %s %s %q", op, i, l.Method, l.Path, l.Body, w.method, w.path, w.body)
+			}
+			if l.Header.Get(BULK_HEADER_LABEL_KEY) != "l" {
+				t.Errorf("%s: request %d has label %q, want l", op, i, l.Header.Get(BULK_HEADER_LABEL_KEY))
+			}
+			if i > 0 && l.Header.Get(BULK_HEADER_TXN_ID_KEY) != "42" {
+				t.Errorf("%s: request %d has txn id %q, want 42", op, i, l.Header.Get(BULK_HEADER_TXN_ID_KEY))
+			}
+		}
+	}
+}